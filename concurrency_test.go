@@ -0,0 +1,163 @@
+package tinyfs_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+
+	"tinygo.org/x/tinyfs"
+	"tinygo.org/x/tinyfs/fatfs"
+	"tinygo.org/x/tinyfs/littlefs"
+)
+
+// threadSafeFilesystems returns constructors for an unformatted instance of
+// each of the filesystem drivers in thread-safe mode.
+func threadSafeFilesystems() map[string]func() tinyfs.Filesystem {
+	return map[string]func() tinyfs.Filesystem{
+		"littlefs": func() tinyfs.Filesystem {
+			dev := tinyfs.NewMemoryDevice(64, 256, 2048).SetStrict(tinyfs.StrictNAND)
+			return littlefs.New(dev).Configure(&littlefs.Config{
+				CacheSize:     128,
+				LookaheadSize: 128,
+				BlockCycles:   500,
+				ThreadSafe:    true,
+			})
+		},
+		"fatfs": func() tinyfs.Filesystem {
+			dev := tinyfs.NewEraseBlockCache(tinyfs.NewMemoryDevice(64, 256, 4096).SetStrict(tinyfs.StrictNOR))
+			return fatfs.New(dev).Configure(&fatfs.Config{
+				SectorSize: fatfs.SectorSize,
+				ThreadSafe: true,
+			})
+		},
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	for name, newFS := range threadSafeFilesystems() {
+		t.Run(name, func(t *testing.T) {
+			testConcurrency(t, mountTestFS(t, newFS))
+		})
+	}
+}
+
+func testConcurrency(t *testing.T, filesystem tinyfs.Filesystem) {
+	const workers = 8
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 64)
+	shared, err := filesystem.OpenFile("/shared.bin", os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shared.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			errs <- hammerFiles(filesystem, i)
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			errs <- hammerReadAt(shared, data, i)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if err := shared.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// hammerFiles creates, checks and removes files of its own while other
+// goroutines do the same.
+func hammerFiles(filesystem tinyfs.Filesystem, worker int) error {
+	dir := fmt.Sprintf("/worker%d", worker)
+	if err := filesystem.Mkdir(dir, 0777); err != nil {
+		return err
+	}
+	for round := 0; round < 20; round++ {
+		name := fmt.Sprintf("%s/file%d.txt", dir, round)
+		contents := []byte(fmt.Sprintf("worker %d wrote round %d", worker, round))
+		f, err := filesystem.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(contents); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+
+		info, err := filesystem.Stat(name)
+		if err != nil {
+			return err
+		}
+		if info.Size() != int64(len(contents)) {
+			return fmt.Errorf("%s: expected size %d, was actually %d", name, len(contents), info.Size())
+		}
+		f, err = filesystem.Open(name)
+		if err != nil {
+			return err
+		}
+		read, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(read, contents) {
+			return fmt.Errorf("%s: expected %q, was actually %q", name, contents, read)
+		}
+
+		d, err := filesystem.Open(dir)
+		if err != nil {
+			return err
+		}
+		infos, err := d.Readdir(0)
+		d.Close()
+		if err != nil {
+			return err
+		}
+		if len(infos) != 1 {
+			return fmt.Errorf("%s: expected 1 entry, was actually %d", dir, len(infos))
+		}
+		if err := filesystem.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hammerReadAt reads from a file that is shared with other goroutines, which
+// must not disturb each other's offsets.
+func hammerReadAt(f tinyfs.File, data []byte, worker int) error {
+	buf := make([]byte, 64)
+	for round := 0; round < 50; round++ {
+		off := int64((worker*37 + round*13) % (len(data) - len(buf)))
+		n, err := f.ReadAt(buf, off)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(buf[:n], data[off:off+int64(n)]) {
+			return fmt.Errorf("ReadAt(%d): expected %q, was actually %q", off, data[off:off+int64(n)], buf[:n])
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return nil
+}
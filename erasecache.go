@@ -0,0 +1,209 @@
+package tinyfs
+
+import "sync"
+
+// EraseBlockCache is a BlockDevice that makes flash memory, which can only be
+// programmed after it has been erased, usable by filesystems that overwrite
+// sectors in place such as FAT. It keeps one erase block in memory, which
+// writes modify, and programs it back to the device when another block is
+// written or when Sync is called. The block is only erased first if a write
+// set bits that programming cannot set; writes that only clear bits, like
+// appending to erased space, are programmed directly.
+//
+// Data that was written but not yet synced is lost on power loss, so the
+// filesystem should sync the device regularly. The fatfs driver does so
+// after every operation that modifies the volume, through CTRL_SYNC.
+type EraseBlockCache struct {
+	dev BlockDevice
+	mu  sync.Mutex
+
+	// buf holds the erase block numbered block, or no block if block is -1
+	buf   []byte
+	block int64
+
+	// dirty is set when buf was written in the range [lo, hi), and
+	// needsErase when such a write set a bit that was clear
+	dirty      bool
+	needsErase bool
+	lo, hi     int64
+}
+
+var _ BlockDevice = (*EraseBlockCache)(nil)
+var _ Syncer = (*EraseBlockCache)(nil)
+
+// NewEraseBlockCache returns a cache for dev, which must read erased memory
+// as 0xff like NOR flash. FAT volumes on flash chips or machine.Flash need it,
+// since FatFs writes sectors without erasing them first.
+func NewEraseBlockCache(dev BlockDevice) *EraseBlockCache {
+	return &EraseBlockCache{
+		dev:   dev,
+		buf:   make([]byte, dev.EraseBlockSize()),
+		block: -1,
+	}
+}
+
+// ReadAt reads from the device, or from the cached block where it overlaps.
+func (c *EraseBlockCache) ReadAt(buf []byte, off int64) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, err := c.dev.ReadAt(buf, off)
+	if c.block >= 0 {
+		start := c.block * int64(len(c.buf))
+		lo, hi := off, off+int64(n)
+		if lo < start {
+			lo = start
+		}
+		if end := start + int64(len(c.buf)); hi > end {
+			hi = end
+		}
+		if lo < hi {
+			copy(buf[lo-off:hi-off], c.buf[lo-start:hi-start])
+		}
+	}
+	return n, err
+}
+
+// WriteAt writes to the cached block, first programming the block that was
+// cached before back to the device if the write is to another block.
+func (c *EraseBlockCache) WriteAt(buf []byte, off int64) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if off < 0 || off+int64(len(buf)) > c.dev.Size() {
+		return 0, ErrOutOfRange
+	}
+	size := int64(len(c.buf))
+	for n := 0; n < len(buf); {
+		pos := off + int64(n)
+		if err := c.load(pos / size); err != nil {
+			return n, err
+		}
+		start := pos % size
+		data := buf[n:]
+		if int64(len(data)) > size-start {
+			data = data[:size-start]
+		}
+		for i, b := range data {
+			if b&^c.buf[start+int64(i)] != 0 {
+				c.needsErase = true
+				break
+			}
+		}
+		copy(c.buf[start:], data)
+		end := start + int64(len(data))
+		if !c.dirty || start < c.lo {
+			c.lo = start
+		}
+		if !c.dirty || end > c.hi {
+			c.hi = end
+		}
+		c.dirty = true
+		n += len(data)
+	}
+	return len(buf), nil
+}
+
+// Size returns the size of the device.
+func (c *EraseBlockCache) Size() int64 {
+	return c.dev.Size()
+}
+
+// WriteBlockSize returns the write block size of the device.
+func (c *EraseBlockCache) WriteBlockSize() int64 {
+	return c.dev.WriteBlockSize()
+}
+
+// EraseBlockSize returns the erase block size of the device.
+func (c *EraseBlockCache) EraseBlockSize() int64 {
+	return int64(len(c.buf))
+}
+
+// EraseBlocks erases blocks of the device, discarding the cached block if it
+// is one of them.
+func (c *EraseBlockCache) EraseBlocks(start, len int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.block >= start && c.block < start+len {
+		c.block, c.dirty, c.needsErase = -1, false, false
+	}
+	return c.dev.EraseBlocks(start, len)
+}
+
+// Sync programs the cached block back to the device, and syncs the device if
+// it is a Syncer.
+func (c *EraseBlockCache) Sync() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.flush(); err != nil {
+		return err
+	}
+	if syncer, ok := c.dev.(Syncer); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
+
+// load makes block the cached block.
+func (c *EraseBlockCache) load(block int64) error {
+	if block == c.block {
+		return nil
+	}
+	if err := c.flush(); err != nil {
+		return err
+	}
+	c.block = -1
+	if _, err := c.dev.ReadAt(c.buf, block*int64(len(c.buf))); err != nil {
+		return err
+	}
+	c.block = block
+	return nil
+}
+
+// flush programs the modified part of the cached block to the device, after
+// erasing the block if needed. Pages that are still erased afterwards are not
+// programmed. The block stays modified if this fails, so that it is tried
+// again.
+func (c *EraseBlockCache) flush() error {
+	if !c.dirty {
+		return nil
+	}
+	page := c.dev.WriteBlockSize()
+	if page <= 0 {
+		page = 1
+	}
+	size := int64(len(c.buf))
+	lo, hi := c.lo/page*page, (c.hi+page-1)/page*page
+	if c.needsErase {
+		if err := c.dev.EraseBlocks(c.block, 1); err != nil {
+			return err
+		}
+		lo, hi = 0, size
+	}
+	if hi > size {
+		hi = size
+	}
+	addr := c.block * size
+	for p := lo; p < hi; p += page {
+		data := c.buf[p:]
+		if int64(len(data)) > page {
+			data = data[:page]
+		}
+		if c.needsErase && erased(data) {
+			continue
+		}
+		if _, err := c.dev.WriteAt(data, addr+p); err != nil {
+			return err
+		}
+	}
+	c.dirty, c.needsErase = false, false
+	return nil
+}
+
+// erased reports whether data reads like erased flash.
+func erased(data []byte) bool {
+	for _, b := range data {
+		if b != 0xff {
+			return false
+		}
+	}
+	return true
+}
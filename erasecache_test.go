@@ -0,0 +1,165 @@
+package tinyfs_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"tinygo.org/x/tinyfs"
+	"tinygo.org/x/tinyfs/fatfs"
+)
+
+// norDevice is a strict NOR MemBlockDevice that counts erases.
+type norDevice struct {
+	*tinyfs.MemBlockDevice
+	erases int
+}
+
+func newNORDevice(pageSize, blockSize, blockCount int) *norDevice {
+	dev := tinyfs.NewMemoryDevice(pageSize, blockSize, blockCount).SetStrict(tinyfs.StrictNOR)
+	return &norDevice{MemBlockDevice: dev}
+}
+
+func (dev *norDevice) EraseBlocks(start, len int64) error {
+	dev.erases += int(len)
+	return dev.MemBlockDevice.EraseBlocks(start, len)
+}
+
+func TestEraseBlockCache(t *testing.T) {
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func(dev tinyfs.BlockDevice, off int64, expected []byte) {
+		t.Helper()
+		buf := make([]byte, len(expected))
+		_, err := dev.ReadAt(buf, off)
+		check(err)
+		if !bytes.Equal(buf, expected) {
+			t.Fatalf("expected %q at %d, was actually %q", expected, off, buf)
+		}
+	}
+
+	nor := newNORDevice(16, 256, 16)
+	cache := tinyfs.NewEraseBlockCache(nor)
+
+	// writes to erased memory only clear bits, across two blocks here
+	data := bytes.Repeat([]byte("0123456789"), 30)
+	_, err := cache.WriteAt(data, 100)
+	check(err)
+	expect(cache, 100, data)
+	check(cache.Sync())
+	expect(nor, 100, data)
+	if nor.erases != 0 {
+		t.Errorf("expected no erases for writes to erased memory, was actually %d", nor.erases)
+	}
+
+	// clearing more bits does not need an erase either
+	_, err = cache.WriteAt([]byte{'0' &^ 1}, 100)
+	check(err)
+	check(cache.Sync())
+	if nor.erases != 0 {
+		t.Errorf("expected no erases for writes that only clear bits, was actually %d", nor.erases)
+	}
+
+	// overwriting with set bits erases the block and keeps the rest of it
+	_, err = cache.WriteAt([]byte("abc"), 120)
+	check(err)
+	expect(cache, 120, []byte("abc"))
+	check(cache.Sync())
+	if nor.erases != 1 {
+		t.Errorf("expected 1 erase, was actually %d", nor.erases)
+	}
+	copy(data[20:], "abc")
+	data[0] &^= 1
+	expect(nor, 100, data)
+
+	// erasing drops the cached block
+	_, err = cache.WriteAt([]byte("zzz"), 0)
+	check(err)
+	check(cache.EraseBlocks(0, 1))
+	check(cache.Sync())
+	expect(cache, 0, bytes.Repeat([]byte{0xff}, 256))
+
+	if _, err := cache.WriteAt([]byte("x"), cache.Size()); !errors.Is(err, tinyfs.ErrOutOfRange) {
+		t.Errorf("expected %v, was actually %v", tinyfs.ErrOutOfRange, err)
+	}
+}
+
+func TestEraseBlockCacheFATFS(t *testing.T) {
+	nor := newNORDevice(256, 4096, 256)
+	filesystem := fatfs.New(tinyfs.NewEraseBlockCache(nor)).Configure(&fatfs.Config{})
+	if err := filesystem.Format(); err != nil {
+		t.Fatal(err)
+	}
+	if err := filesystem.Mount(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		// rewriting the same files overwrites the FAT and directory in place
+		for j := 0; j < 5; j++ {
+			f, err := filesystem.OpenFile(fmt.Sprintf("/file%d.txt", j), os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Write(bytes.Repeat([]byte(fmt.Sprint(i)), 1000*j)); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := filesystem.Unmount(); err != nil {
+		t.Fatal(err)
+	}
+
+	// everything reached the flash
+	filesystem = fatfs.New(tinyfs.NewEraseBlockCache(nor)).Configure(&fatfs.Config{})
+	if err := filesystem.Mount(); err != nil {
+		t.Fatal(err)
+	}
+	defer filesystem.Unmount()
+	for j := 0; j < 5; j++ {
+		info, err := filesystem.Stat(fmt.Sprintf("/file%d.txt", j))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(1000*j) {
+			t.Errorf("expected size %d, was actually %d", 1000*j, info.Size())
+		}
+	}
+	f, err := filesystem.Open("/file4.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := make([]byte, 4000)
+	if _, err := f.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, bytes.Repeat([]byte("9"), 4000)) {
+		t.Errorf("expected the contents of the last write, was actually %q", buf[:20])
+	}
+
+	// without the cache, the in-place updates of FAT fail on NOR flash
+	bare := fatfs.New(newNORDevice(256, 4096, 256)).Configure(&fatfs.Config{})
+	err = bare.Format()
+	if err == nil {
+		err = bare.Mount()
+	}
+	if err == nil {
+		defer bare.Unmount()
+		f, err = bare.OpenFile("/file.txt", os.O_WRONLY|os.O_CREATE)
+		if err == nil {
+			err = f.Close()
+		}
+	}
+	if err == nil {
+		t.Error("expected writing to NOR flash without erasing to fail")
+	}
+}
@@ -6,13 +6,14 @@ package main
 import (
 	"machine"
 
+	"tinygo.org/x/tinyfs"
 	"tinygo.org/x/tinyfs/examples/console"
 	"tinygo.org/x/tinyfs/fatfs"
 )
 
 var (
 	blockDevice = machine.Flash
-	filesystem  = fatfs.New(blockDevice)
+	filesystem  = fatfs.New(tinyfs.NewEraseBlockCache(blockDevice))
 )
 
 func main() {
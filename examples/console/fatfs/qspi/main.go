@@ -1,3 +1,4 @@
+//go:build tinygo
 // +build tinygo
 
 package main
@@ -7,6 +8,7 @@ import (
 	"time"
 
 	"tinygo.org/x/drivers/flash"
+	"tinygo.org/x/tinyfs"
 	"tinygo.org/x/tinyfs/examples/console"
 	"tinygo.org/x/tinyfs/fatfs"
 )
@@ -21,7 +23,7 @@ var (
 		machine.QSPI_DATA3,
 	)
 
-	filesystem = fatfs.New(blockDevice)
+	filesystem = fatfs.New(tinyfs.NewEraseBlockCache(blockDevice))
 )
 
 func main() {
@@ -1,3 +1,4 @@
+//go:build tinygo
 // +build tinygo
 
 package main
@@ -7,6 +8,7 @@ import (
 	"time"
 
 	"tinygo.org/x/drivers/flash"
+	"tinygo.org/x/tinyfs"
 	"tinygo.org/x/tinyfs/examples/console"
 	"tinygo.org/x/tinyfs/fatfs"
 )
@@ -20,7 +22,7 @@ var (
 		machine.SPI1_CS_PIN,
 	)
 
-	filesystem = fatfs.New(blockDevice)
+	filesystem = fatfs.New(tinyfs.NewEraseBlockCache(blockDevice))
 )
 
 func main() {
@@ -254,7 +254,7 @@
 #endif
 #define GET_FATTIME()   ((DWORD)(FF_NORTC_YEAR - 1980) << 25 | (DWORD)FF_NORTC_MON << 21 | (DWORD)FF_NORTC_MDAY << 16)
 #else
-#define GET_FATTIME()   get_fattime()
+#define GET_FATTIME()   get_fattime(fs->drv)
 #endif
 
 
@@ -5386,14 +5386,16 @@ FRESULT f_forward (
 
 FRESULT f_mkfs (
     FATFS *fs,
-    BYTE opt,           /* Format option */
-    DWORD au,           /* Size of allocation unit (cluster) [byte] */
+    const MKFS_PARM* mkfs_opt,  /* Format options */
     void* work,         /* Pointer to working buffer (null: use heap memory) */
     UINT len            /* Size of working buffer [byte] */
 )
 {
-    const UINT n_fats = 1;      /* Number of FATs for FAT/FAT32 volume (1 or 2) */
-    const UINT n_rootdir = 512; /* Number of root directory entries for FAT volume */
+    BYTE opt = mkfs_opt->fmt;           /* Format option */
+    DWORD au = mkfs_opt->au_size;       /* Size of allocation unit (cluster) [byte] */
+    UINT n_fats = mkfs_opt->n_fat;      /* Number of FATs for FAT/FAT32 volume (1 or 2) */
+    UINT n_rootdir = mkfs_opt->n_root;  /* Number of root directory entries for FAT volume */
+    int au_auto;
     static const WORD cst[] = {1, 4, 16, 64, 256, 512, 0};  /* Cluster size boundary for FAT volume (4Ks unit) */
     static const WORD cst32[] = {1, 2, 4, 8, 16, 32, 0};    /* Cluster size boundary for FAT32 volume (128Ks unit) */
     BYTE fmt, sys, *buf, *pte, part; void *pdrv;
@@ -5417,7 +5419,9 @@ FRESULT f_mkfs (
     disk_ioctl(pdrv, IOCTL_INIT, &stat);
     if (stat & STA_NOINIT) return FR_NOT_READY;
     if (stat & STA_PROTECT) return FR_WRITE_PROTECTED;
-    if (disk_ioctl(pdrv, GET_BLOCK_SIZE, &sz_blk) != RES_OK || !sz_blk || sz_blk > 32768 || (sz_blk & (sz_blk - 1))) sz_blk = 1;    /* Erase block to align data area */
+    sz_blk = mkfs_opt->align;   /* Erase block to align data area */
+    if (sz_blk == 0 && disk_ioctl(pdrv, GET_BLOCK_SIZE, &sz_blk) != RES_OK) sz_blk = 1;
+    if (!sz_blk || sz_blk > 32768 || (sz_blk & (sz_blk - 1))) sz_blk = 1;
 #if FF_MAX_SS != FF_MIN_SS      /* Get sector size of the medium if variable sector size cfg. */
     if (disk_ioctl(pdrv, GET_SECTOR_SIZE, &ss) != RES_OK) return FR_DISK_ERR;
     if (ss > FF_MAX_SS || ss < FF_MIN_SS || (ss & (ss - 1))) return FR_DISK_ERR;
@@ -5426,6 +5430,9 @@ FRESULT f_mkfs (
 #endif
     if ((au != 0 && au < ss) || au > 0x1000000 || (au & (au - 1))) return FR_INVALID_PARAMETER; /* Check if au is valid */
     au /= ss;   /* Cluster size in unit of sector */
+    au_auto = (au == 0);
+    if (n_fats != 1 && n_fats != 2) n_fats = 1;
+    if (n_rootdir == 0 || n_rootdir > 0x7FFF || n_rootdir % (ss / SZDIRE)) n_rootdir = 512;
 
     /* Get working buffer */
 #if FF_USE_LFN == 3
@@ -5660,6 +5667,7 @@ FRESULT f_mkfs (
                 }
                 n_clst = sz_vol / pau;
                 if (n_clst > MAX_FAT12) {
+                    fmt = FS_FAT16;         /* Undo FAT12 of a previous try */
                     n = n_clst * 2 + 4;     /* FAT size [byte] */
                 } else {
                     fmt = FS_FAT12;
@@ -5677,6 +5685,9 @@ FRESULT f_mkfs (
             if (fmt == FS_FAT32) {      /* FAT32: Move FAT base */
                 sz_rsv += n; b_fat += n;
             } else {                    /* FAT: Expand FAT size */
+                if (n % n_fats) {       /* Move FAT base by the sector the FATs cannot share */
+                    n--; sz_rsv++; b_fat++;
+                }
                 sz_fat += n / n_fats;
             }
 
@@ -5706,6 +5717,18 @@ FRESULT f_mkfs (
                 }
             }
             if (fmt == FS_FAT12 && n_clst > MAX_FAT12) LEAVE_MKFS(FR_MKFS_ABORTED); /* Too many clusters for FAT12 */
+            if (mkfs_opt->fat_type == FS_FAT12 && fmt != FS_FAT12) {    /* FAT12 wanted, but too many clusters */
+                if (au_auto && pau * 2 <= 128) {
+                    au = pau * 2; continue;     /* Adjust cluster size and retry */
+                }
+                LEAVE_MKFS(FR_MKFS_ABORTED);
+            }
+            if (mkfs_opt->fat_type == FS_FAT16 && fmt != FS_FAT16) {    /* FAT16 wanted, but too few clusters */
+                if (au_auto && pau > 1) {
+                    au = pau / 2; continue;     /* Adjust cluster size and retry */
+                }
+                LEAVE_MKFS(FR_MKFS_ABORTED);
+            }
 
             /* Ok, it is the valid cluster configuration */
             break;
@@ -250,6 +250,19 @@ typedef struct {
 
 
 
+/* Format parameter structure (MKFS_PARM) */
+
+typedef struct {
+    BYTE fmt;       /* Format option (FM_FAT, FM_FAT32, FM_EXFAT and FM_SFD) */
+    BYTE n_fat;     /* Number of FATs (1 or 2, 0:1) */
+    UINT align;     /* Data area alignment (sector, 0:GET_BLOCK_SIZE) */
+    UINT n_root;    /* Number of root directory entries (0:512) */
+    DWORD au_size;  /* Cluster size (byte, 0:auto) */
+    BYTE fat_type;  /* Sub-type of a FAT volume with FM_FAT (0:auto, FS_FAT12 or FS_FAT16) */
+} MKFS_PARM;
+
+
+
 /* File function return code (FRESULT) */
 
 typedef enum {
@@ -307,7 +320,7 @@ FRESULT f_forward (FIL* fp, UINT(*func)(const BYTE*,UINT), UINT btf, UINT* bf);
 FRESULT f_expand (FIL* fp, FSIZE_t fsz, BYTE opt);                  /* Allocate a contiguous block to the file */
 FRESULT f_mount (FATFS* fs);                                        /* Mount/Unmount a logical drive */
 FRESULT f_umount (FATFS* fs);                                       /* Unmount a logical drive */
-FRESULT f_mkfs (FATFS *fs, BYTE opt, DWORD au, void* work, UINT len); /* Create a FAT volume */
+FRESULT f_mkfs (FATFS *fs, const MKFS_PARM* opt, void* work, UINT len);    /* Create a FAT volume */
 FRESULT f_fdisk (void *pdrv, const DWORD* szt, void* work);         /* Divide a physical drive into some partitions */
 FRESULT f_setcp (WORD cp);                                          /* Set current code page */
 FRESULT f_repair (FATFS* fs, void* work, UINT len);                 /* Free unreferenced clusters from the FAT */
@@ -333,7 +346,7 @@ FRESULT f_repair (FATFS* fs, void* work, UINT len);                 /* Free unre
 
 /* RTC function */
 #if !FF_FS_READONLY && !FF_FS_NORTC
-DWORD get_fattime (void);
+DWORD get_fattime (void *drv);
 #endif
 
 /* LFN support functions */
@@ -374,7 +387,7 @@ int ff_del_syncobj (FF_SYNC_t sobj);    /* Delete a sync object */
 /* Fast seek controls (2nd argument of f_lseek) */
 #define CREATE_LINKMAP  ((FSIZE_t)0 - 1)
 
-/* Format options (2nd argument of f_mkfs) */
+/* Format options (fmt member of MKFS_PARM) */
 #define FM_FAT      0x01
 #define FM_FAT32    0x02
 #define FM_EXFAT    0x04
@@ -54,7 +54,7 @@
 /* This option switches f_expand function. (0:Disable or 1:Enable) */
 
 
-#define FF_USE_CHMOD    0
+#define FF_USE_CHMOD    1
 /* This option switches attribute manipulation functions, f_chmod() and f_utime().
 /  (0:Disable or 1:Enable) Also FF_FS_READONLY needs to be 0 to enable this option. */
 
@@ -76,10 +76,17 @@
 / Locale and Namespace Configurations
 /---------------------------------------------------------------------------*/
 
-#define FF_CODE_PAGE    932
+#ifndef FF_CODE_PAGE
+#define FF_CODE_PAGE    437
+#endif
 /* This option specifies the OEM code page to be used on the target system.
 /  Incorrect code page setting can cause a file open failure.
 /
+/  The Go API always uses UTF-8 names (FF_LFN_UNICODE 2), so the code page only
+/  affects the short names stored next to long names, and the names of files
+/  written by systems without LFN support. It can be chosen at build time, for
+/  instance with CGO_CFLAGS=-DFF_CODE_PAGE=850.
+/
 /   437 - U.S.
 /   720 - Arabic
 /   737 - Greek
@@ -125,7 +132,7 @@
 /  ff_memfree() in ffsystem.c, need to be added to the project. */
 
 
-#define FF_LFN_UNICODE  0
+#define FF_LFN_UNICODE  2
 /* This option switches the character encoding on the API when LFN is enabled.
 /
 /   0: ANSI/OEM in current CP (TCHAR = char)
@@ -137,12 +144,18 @@
 /  When LFN is not enabled, this option has no effect. */
 
 
-#define FF_LFN_BUF      255
-#define FF_SFN_BUF      12
+#ifndef FF_LFN_BUF
+#define FF_LFN_BUF      765
+#endif
+#define FF_SFN_BUF      36
 /* This set of options defines size of file name members in the FILINFO structure
 /  which is used to read out directory items. These values should be suffcient for
 /  the file names to read. The maximum possible length of the read file name depends
-/  on character encoding. When LFN is not enabled, these options have no effect. */
+/  on character encoding. When LFN is not enabled, these options have no effect.
+/  In UTF-8, each of the FF_MAX_LFN UTF-16 code units of a name takes up to 3 bytes,
+/  and so does each of the 12 characters of a short name; a name that does not fit
+/  would be returned as an empty string. Builds that only use ASCII names can save
+/  the stack taken by each FILINFO with CGO_CFLAGS=-DFF_LFN_BUF=255. */
 
 
 #define FF_STRF_ENCODE  3
@@ -199,13 +212,20 @@
 
 
 #define FF_MIN_SS       512
-#define FF_MAX_SS       512
+#ifndef FF_MAX_SS
+#define FF_MAX_SS       4096
+#endif
 /* This set of options configures the range of sector size to be supported. (512,
 /  1024, 2048 or 4096) Always set both 512 for most systems, generic memory card and
 /  harddisk. But a larger value may be required for on-board flash memory and some
 /  type of optical media. When FF_MAX_SS is larger than FF_MIN_SS, FatFs is configured
 /  for variable sector size mode and disk_ioctl() function needs to implement
-/  GET_SECTOR_SIZE command. */
+/  GET_SECTOR_SIZE command.
+/
+/  The sector size is chosen per volume with Config.SectorSize. The volume and every
+/  open file carry a buffer of FF_MAX_SS bytes, so boards that only use 512-byte
+/  sectors can save memory with the fatfs_512 build tag, which lowers FF_MAX_SS to
+/  512. */
 
 
 #define FF_USE_TRIM     0
@@ -238,10 +258,14 @@
 /  buffer in the filesystem object (FATFS) is used for the file data transfer. */
 
 
-#define FF_FS_EXFAT     0
+#ifndef FF_FS_EXFAT
+#define FF_FS_EXFAT     1
+#endif
 /* This option switches support for exFAT filesystem. (0:Disable or 1:Enable)
 /  To enable exFAT, also LFN needs to be enabled. (FF_USE_LFN >= 1)
-/  Note that enabling exFAT discards ANSI C (C89) compatibility. */
+/  Note that enabling exFAT discards ANSI C (C89) compatibility.
+/  exFAT enlarges the volume, file and directory objects and the LFN working buffer
+/  on the stack; boards that only use FAT can drop it with CGO_CFLAGS=-DFF_FS_EXFAT=0. */
 
 
 #define FF_FS_NORTC     0
@@ -258,7 +282,9 @@
 /  These options have no effect at read-only configuration (FF_FS_READONLY = 1). */
 
 
+#ifndef FF_FS_LOCK
 #define FF_FS_LOCK      0
+#endif
 /* The option FF_FS_LOCK switches file lock function to control duplicated file open
 /  and illegal operation to open objects. This option must be 0 when FF_FS_READONLY
 /  is 1.
@@ -267,13 +293,15 @@
 /      should avoid illegal open, remove and rename to the open objects.
 /  >0: Enable file lock function. The value defines how many files/sub-directories
 /      can be opened simultaneously under file lock control. Note that the file
-/      lock control is independent of re-entrancy. */
+/      lock control is independent of re-entrancy.
+/
+/  It is enabled by the fatfs_lock build tag, see go_fatfs_lock.go. */
 
 
 /* #include <somertos.h>    // O/S definitions */
-#define FF_FS_REENTRANT 0
+#define FF_FS_REENTRANT 1
 #define FF_FS_TIMEOUT   1000
-#define FF_SYNC_t       HANDLE
+#define FF_SYNC_t       void*
 /* The option FF_FS_REENTRANT switches the re-entrancy (thread safe) of the FatFs
 /  module itself. Note that regardless of this option, file access to different
 /  volume is always re-entrant and volume control functions, f_mount(), f_mkfs()
@@ -289,7 +317,10 @@
 /  The FF_FS_TIMEOUT defines timeout period in unit of time tick.
 /  The FF_SYNC_t defines O/S dependent sync object type. e.g. HANDLE, ID, OS_EVENT*,
 /  SemaphoreHandle_t and etc. A header file for O/S definitions needs to be
-/  included somewhere in the scope of ff.h. */
+/  included somewhere in the scope of ff.h.
+/
+/  Re-entrancy is always compiled in, and Config.ThreadSafe chooses it per volume:
+/  the handlers in go_fatfs.c return at once for volumes without a sync object. */
 
 
 
@@ -14,14 +14,50 @@ DRESULT disk_ioctl(void *drv, BYTE cmd, void* buff) {
     return go_fatfs_disk_ioctl(drv, cmd, buff);
 }
 
-DWORD get_fattime() {
-    return go_fatfs_get_fattime();
+DWORD get_fattime(void *drv) {
+    return go_fatfs_get_fattime(drv);
+}
+
+// implementation of the OS dependent functions defined in ff.h, which take
+// the place of the sample ffsystem.c that comes with FatFs
+
+#if FF_USE_LFN == 3
+
+void* ff_memalloc(UINT msize) {
+    return malloc(msize);
+}
+
+void ff_memfree(void* mblock) {
+    free(mblock);
+}
+
+#endif
+
+// The sync object of a volume is set up by Configure, and is NULL unless the
+// volume is thread-safe, in which case it points to the Go FATFS like drv.
+
+int ff_cre_syncobj(FATFS *fatfs, FF_SYNC_t *sobj) {
+    return 1;
+}
+
+int ff_del_syncobj(FF_SYNC_t sobj) {
+    return 1;
+}
+
+int ff_req_grant(FF_SYNC_t sobj) {
+    return sobj == NULL || go_fatfs_req_grant(sobj);
+}
+
+void ff_rel_grant(FF_SYNC_t sobj) {
+    if (sobj != NULL) {
+        go_fatfs_rel_grant(sobj);
+    }
 }
 
 // Helper functions for creating FatFs structs
 
 FATFS* go_fatfs_new_fatfs(void) {
-    return malloc(sizeof(FATFS));
+    return calloc(1, sizeof(FATFS));
 }
 
 FIL* go_fatfs_new_fil(void) {
@@ -36,7 +72,7 @@ FF_DIR* go_fatfs_new_ff_dir(void) {
 // which prevents the Go code from linking properly.
 #if FF_FS_READONLY == 1
 
-FRESULT f_mkfs (FATFS *fs, BYTE opt, DWORD au, void* work, UINT len) {
+FRESULT f_mkfs (FATFS *fs, const MKFS_PARM* opt, void* work, UINT len) {
     return 99;
 }
 
@@ -64,4 +100,8 @@ FRESULT f_getfree (FATFS *fs, DWORD* nclst) {
     return 99;
 }
 
+FRESULT f_utime (FATFS *fs, const TCHAR* path, const FILINFO* fno) {
+    return 99;
+}
+
 #endif
\ No newline at end of file
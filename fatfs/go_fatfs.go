@@ -6,12 +6,20 @@ package fatfs
 import "C"
 import (
 	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"math"
 	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 	"unsafe"
 
 	"tinygo.org/x/tinyfs"
+	"tinygo.org/x/tinyfs/internal/flock"
 	"tinygo.org/x/tinyfs/internal/gopointer"
 	"tinygo.org/x/tinyfs/internal/util"
 )
@@ -37,6 +45,7 @@ const (
 	FileResultNotEnoughCore    FileResult = C.FR_NOT_ENOUGH_CORE
 	FileResultTooManyOpenFiles FileResult = C.FR_TOO_MANY_OPEN_FILES
 	FileResultInvalidParameter FileResult = C.FR_INVALID_PARAMETER
+	FileResultNotEmpty         FileResult = 98
 	FileResultReadOnly         FileResult = 99
 
 	TypeFAT12 Type = C.FS_FAT12
@@ -50,8 +59,43 @@ const (
 	AttrDirectory FileAttr = C.AM_DIR
 	AttrArchive   FileAttr = C.AM_ARC
 
+	// SectorSize is the default size of a logical sector. The sector size of
+	// a volume can be any power of two from MinSectorSize to MaxSectorSize.
 	SectorSize = 512
 
+	// MinSectorSize and MaxSectorSize are the range of sector sizes supported,
+	// which is set by FF_MIN_SS and FF_MAX_SS in ffconf.h. The fatfs_512 build
+	// tag lowers MaxSectorSize from 4096 to 512 to save memory.
+	MinSectorSize = C.FF_MIN_SS
+	MaxSectorSize = C.FF_MAX_SS
+
+	// ExFAT reports whether exFAT volumes are supported, which is set by
+	// FF_FS_EXFAT in ffconf.h.
+	ExFAT = C.FF_FS_EXFAT != 0
+
+	// CodePage is the OEM code page used for the short names of files, which
+	// is set by FF_CODE_PAGE in ffconf.h. File names in the API are UTF-8
+	// regardless.
+	CodePage = C.FF_CODE_PAGE
+
+	accessModeMask = os.O_RDONLY | os.O_WRONLY | os.O_RDWR
+
+	// maxFATFileSize is the largest file size representable on a FAT12/16/32
+	// volume
+	maxFATFileSize = 1<<32 - 1
+
+	// extendChunkSize is the size of the writes used to fill files with zeros
+	extendChunkSize = 4096
+
+	// maxFATSectorSize is the largest sector size a FAT or exFAT volume can
+	// have, which may be larger than MaxSectorSize
+	maxFATSectorSize = 4096
+
+	// lfnBufSize is the number of bytes FILINFO holds for a long name, which
+	// is set by FF_LFN_BUF in ffconf.h. Longer names read back as the short
+	// name of the file.
+	lfnBufSize = C.FF_LFN_BUF
+
 	FileAccessRead         OpenFlag = C.FA_READ
 	FileAccessWrite        OpenFlag = C.FA_WRITE
 	FileAccessOpenExisting OpenFlag = C.FA_OPEN_EXISTING
@@ -120,9 +164,11 @@ func (r FileResult) Error() string {
 	case FileResultNotEnoughCore:
 		msg = "(17) LFN working buffer could not be allocated"
 	case FileResultTooManyOpenFiles:
-		msg = "(18) Number of open files > FF_FS_LOCK"
+		msg = "(18) Number of open files > FF_FS_LOCK or Config.MaxOpenFiles"
 	case FileResultInvalidParameter:
 		msg = "(19) Given parameter is invalid"
+	case FileResultNotEmpty:
+		msg = "(98) Directory not empty"
 	case FileResultReadOnly:
 		msg = "(99) Read-only filesystem"
 	default:
@@ -131,12 +177,40 @@ func (r FileResult) Error() string {
 	return "fatfs: " + msg
 }
 
+// Is reports whether r matches one of the portable error values in the io/fs
+// package, so that errors.Is(err, fs.ErrNotExist) and friends can be used
+// without knowledge of FatFs result codes.
+func (r FileResult) Is(target error) bool {
+	switch target {
+	case fs.ErrNotExist:
+		return r == FileResultNoFile || r == FileResultNoPath
+	case fs.ErrExist:
+		return r == FileResultExist || r == FileResultNotEmpty
+	case fs.ErrPermission:
+		return r == FileResultDenied || r == FileResultWriteProtected ||
+			r == FileResultLocked || r == FileResultReadOnly
+	case fs.ErrInvalid:
+		return r == FileResultInvalidName || r == FileResultInvalidParameter ||
+			r == FileResultInvalidDrive
+	case fs.ErrClosed:
+		return r == FileResultInvalidObject
+	case os.ErrDeadlineExceeded:
+		return r == FileResultTimeout
+	case tinyfs.ErrLocked:
+		return r == FileResultLocked
+	case tinyfs.ErrTooManyOpenFiles:
+		return r == FileResultTooManyOpenFiles
+	}
+	return false
+}
+
 type FileAttr byte
 
 type Info struct {
-	size int64
-	name string
-	attr FileAttr
+	size  int64
+	name  string
+	attr  FileAttr
+	mtime time.Time
 }
 
 var _ os.FileInfo = (*Info)(nil)
@@ -165,17 +239,140 @@ func (info *Info) Mode() os.FileMode {
 	return v
 }
 
+// ModTime returns the modification time of the file. FAT timestamps are stored
+// in local time with a resolution of two seconds.
 func (info *Info) ModTime() time.Time {
-	return time.Time{}
+	return info.mtime
+}
+
+// newInfo creates an Info from the FILINFO structure filled in by FatFs.
+func newInfo(info *C.FILINFO, name string) *Info {
+	return &Info{
+		size:  int64(info.fsize),
+		name:  name,
+		attr:  FileAttr(info.fattrib),
+		mtime: decodeFATTime(uint16(info.fdate), uint16(info.ftime)),
+	}
+}
+
+// decodeFATTime converts the date and time fields of a FAT directory entry
+// into a time.Time.
+func decodeFATTime(fdate uint16, ftime uint16) time.Time {
+	if fdate == 0 {
+		return time.Time{}
+	}
+	return time.Date(
+		1980+int(fdate>>9), time.Month(fdate>>5&0xF), int(fdate&0x1F),
+		int(ftime>>11), int(ftime>>5&0x3F), int(ftime&0x1F)*2, 0, time.Local)
+}
+
+// encodeFATTime packs t into the format returned by get_fattime, with the date
+// in the upper and the time in the lower 16 bits. It reports false if t cannot
+// be represented on a FAT volume, which covers the years 1980 to 2107.
+func encodeFATTime(t time.Time) (uint32, bool) {
+	t = t.In(time.Local)
+	year, month, day := t.Date()
+	if year < 1980 || year > 2107 {
+		return 0, false
+	}
+	hour, minute, second := t.Clock()
+	return uint32(year-1980)<<25 | uint32(month)<<21 | uint32(day)<<16 |
+		uint32(hour)<<11 | uint32(minute)<<5 | uint32(second/2), true
 }
 
 type FATFS struct {
-	dev tinyfs.BlockDevice
-	fs  *C.FATFS
+	dev   tinyfs.BlockDevice
+	fs    *C.FATFS
+	clock func() time.Time
+
+	// sectorSize is the sector size for Format, and ssize the one of the
+	// volume that is mounted or being formatted, which FatFs asks for with
+	// GET_SECTOR_SIZE and which the disk callbacks use.
+	sectorSize int
+	ssize      int
+
+	mounted      bool
+	files        map[*File]struct{}
+	filesMu      sync.Mutex
+	maxOpenFiles int
+
+	// locks holds the advisory locks of the open files
+	locks flock.Table
+
+	// grant is held by FatFs around every operation in thread-safe mode. It
+	// is a channel rather than a sync.Mutex so that waiting for it can time
+	// out.
+	grant       chan struct{}
+	lockTimeout time.Duration
 }
 
 type Config struct {
+	// SectorSize is the size in bytes of the logical sectors of volumes
+	// created by Format, a power of two from MinSectorSize to MaxSectorSize.
+	// Larger sectors can be much faster on flash memory with large pages,
+	// such as SPI NOR and eMMC. Defaults to 512. Mount detects the sector
+	// size of an existing volume from its boot sector.
 	SectorSize int
+
+	// Clock returns the current time, which is used to timestamp files and
+	// directories on this volume. When it is nil, or returns a time that FAT
+	// cannot represent, the fixed date configured by FF_NORTC_YEAR,
+	// FF_NORTC_MON and FF_NORTC_MDAY in ffconf.h is used instead; this suits
+	// boards without a real-time clock and keeps images reproducible.
+	Clock func() time.Time
+
+	// ThreadSafe makes it safe to use the volume and its files from several
+	// goroutines at once. FatFs then serializes its operations on the volume,
+	// and each file serializes the operations that depend on its offset.
+	// Mount, Unmount, Format and Close must still not be called concurrently
+	// with other operations.
+	ThreadSafe bool
+
+	// LockTimeout is how long an operation waits for another one on the same
+	// volume to finish in thread-safe mode, before it fails with
+	// FileResultTimeout. Defaults to FF_FS_TIMEOUT milliseconds.
+	LockTimeout time.Duration
+
+	// MaxOpenFiles limits the number of files and directories that can be
+	// open on the volume at once. Opening more fails with
+	// FileResultTooManyOpenFiles. Zero means no limit, other than the one
+	// FF_FS_LOCK places on all volumes together when the FatFs sharing
+	// policy is enabled.
+	MaxOpenFiles int
+}
+
+// FormatOptions controls the layout of the volume created by
+// FormatWithOptions. The zero value of each field picks the same default as
+// Format.
+type FormatOptions struct {
+	// Type is the type of the volume. Zero picks one from the size of the
+	// volume, as Format does. A volume that is too small or too large for the
+	// requested type fails to format with FileResultMkfsAborted.
+	Type Type
+
+	// AllocationUnit is the size of a cluster in bytes, a power of two from
+	// SectorSize up to 64 KiB for FAT and 16 MiB for exFAT. Zero picks one
+	// from the size of the volume.
+	AllocationUnit int
+
+	// NumFATs is the number of copies of the FAT on FAT12/16/32 volumes, 1 or
+	// 2. Defaults to 1.
+	NumFATs int
+
+	// RootEntries is the number of entries in the root directory of FAT12/16
+	// volumes, a multiple of SectorSize/32 up to 32767. Defaults to 512.
+	RootEntries int
+
+	// Alignment is the boundary in bytes that the data area, and on exFAT
+	// the FAT, is aligned to, a power of two. Defaults to the EraseBlockSize
+	// of the block device, so that clusters do not straddle erase blocks;
+	// SectorSize disables alignment.
+	Alignment int
+
+	// NoPartitionTable formats the whole device as a single volume starting
+	// at sector 0, like a floppy disk, instead of creating a partition table
+	// with one partition.
+	NoPartitionTable bool
 }
 
 func New(blockdev tinyfs.BlockDevice) *FATFS {
@@ -184,65 +381,503 @@ func New(blockdev tinyfs.BlockDevice) *FATFS {
 	}
 }
 
+// Configure sets up the filesystem with the given configuration. If the
+// filesystem was configured before, it is closed first.
 func (l *FATFS) Configure(config *Config) *FATFS {
+	l.Close()
 	l.fs = C.go_fatfs_new_fatfs()
 	l.fs.drv = gopointer.Save(l)
+	l.clock = config.Clock
+	l.sectorSize = config.SectorSize
+	if l.sectorSize == 0 {
+		l.sectorSize = SectorSize
+	}
+	l.ssize = l.sectorSize
+	l.maxOpenFiles = config.MaxOpenFiles
+	l.grant, l.fs.sobj = nil, nil
+	if config.ThreadSafe {
+		l.grant = make(chan struct{}, 1)
+		l.lockTimeout = config.LockTimeout
+		if l.lockTimeout <= 0 {
+			l.lockTimeout = C.FF_FS_TIMEOUT * time.Millisecond
+		}
+		l.fs.sobj = l.fs.drv
+	}
 	return l
 }
 
+// Mount mounts the volume. A volume that is already mounted is unmounted
+// first. FatFs numbers sectors with 32 bits, so on devices with more than
+// 2^32 sectors only volumes that lie within the first 2^32 can be mounted.
 func (l *FATFS) Mount() error {
-	return errval(C.f_mount(l.fs))
+	if l.fs == nil {
+		return pathError("mount", "/", FileResultNotEnabled)
+	}
+	if err := l.Unmount(); err != nil {
+		return err
+	}
+	if !validSectorSize(l.sectorSize) {
+		return pathError("mount", "/", FileResultInvalidParameter)
+	}
+	ssize, err := l.detectSectorSize()
+	if err != nil {
+		return pathError("mount", "/", FileResultErr)
+	}
+	if ssize > MaxSectorSize {
+		// the build has smaller buffers than the sectors of the volume
+		return pathError("mount", "/", fmt.Errorf("fatfs: the volume has %d-byte sectors, more than MaxSectorSize (%d); build without the fatfs_512 tag to mount it: %w",
+			ssize, MaxSectorSize, FileResultInvalidParameter))
+	}
+	l.ssize = ssize
+	if err := errval(C.f_mount(l.fs)); err != nil {
+		return pathError("mount", "/", err)
+	}
+	l.mounted = true
+	return nil
 }
 
+// Format creates a FAT volume on the block device, unmounting the volume
+// first if it is mounted. The type of the volume depends on its size, much
+// like on SD cards: FAT12 or FAT16 for small volumes, FAT32 for larger ones
+// and exFAT from 32 GiB on. Only the first 2^32 sectors of larger devices are
+// used, which is 2 TiB with 512-byte sectors.
 func (l *FATFS) Format() error {
-	work := make([]byte, SectorSize)
-	return errval(C.f_mkfs(l.fs, C.FM_FAT, 0, unsafe.Pointer(&work[0]), C.UINT(len(work))))
+	return l.FormatWithOptions(&FormatOptions{})
+}
+
+// FormatWithOptions creates a volume on the block device like Format, with
+// the layout given by opts.
+func (l *FATFS) FormatWithOptions(opts *FormatOptions) error {
+	if l.fs == nil {
+		return pathError("format", "/", FileResultNotEnabled)
+	}
+	var parm C.MKFS_PARM
+	switch opts.Type {
+	case 0:
+		parm.fmt = C.FM_ANY
+	case TypeFAT12, TypeFAT16:
+		parm.fmt = C.FM_FAT
+		parm.fat_type = C.BYTE(opts.Type)
+	case TypeFAT32:
+		parm.fmt = C.FM_FAT32
+	case TypeEXFAT:
+		parm.fmt = C.FM_EXFAT
+	default:
+		return pathError("format", "/", FileResultInvalidParameter)
+	}
+	if opts.NoPartitionTable {
+		parm.fmt |= C.FM_SFD
+	}
+	ss := l.sectorSize
+	if !validSectorSize(ss) ||
+		!validFormatSize(opts.AllocationUnit, ss, 1<<24) ||
+		!validFormatSize(opts.Alignment, ss, 32768*ss) ||
+		opts.NumFATs < 0 || opts.NumFATs > 2 ||
+		opts.RootEntries < 0 || opts.RootEntries > 0x7FFF || opts.RootEntries%(ss/32) != 0 {
+		return pathError("format", "/", FileResultInvalidParameter)
+	}
+	parm.au_size = C.DWORD(opts.AllocationUnit)
+	parm.align = C.UINT(opts.Alignment / ss)
+	parm.n_fat = C.BYTE(opts.NumFATs)
+	parm.n_root = C.UINT(opts.RootEntries)
+	if err := l.Unmount(); err != nil {
+		return err
+	}
+	l.ssize = ss
+	work := make([]byte, ss)
+	return pathError("format", "/", errval(C.f_mkfs(l.fs, &parm, unsafe.Pointer(&work[0]), C.UINT(len(work)))))
+}
+
+// validSectorSize reports whether size is a sector size supported by FatFs.
+func validSectorSize(size int) bool {
+	return size >= MinSectorSize && size <= MaxSectorSize && size&(size-1) == 0
+}
+
+// detectSectorSize returns the sector size of the volume on the block
+// device, which is read from the boot sector of the volume at the start of
+// the device or in one of the partitions of its partition table. The
+// configured sector size is returned if no volume is found, so that FatFs
+// reports the missing filesystem. Errors reading the boot sector are
+// returned.
+func (l *FATFS) detectSectorSize() (int, error) {
+	buf := make([]byte, 512)
+	if _, err := l.dev.ReadAt(buf, 0); err != nil {
+		return 0, err
+	}
+	if ss := bootSectorSize(buf); ss != 0 {
+		return ss, nil
+	}
+	if buf[510] != 0x55 || buf[511] != 0xAA {
+		return l.sectorSize, nil
+	}
+	mbr := append([]byte(nil), buf...)
+	for i := 0; i < 4; i++ {
+		entry := mbr[446+16*i:]
+		lba := int64(entry[8]) | int64(entry[9])<<8 | int64(entry[10])<<16 | int64(entry[11])<<24
+		if entry[4] == 0 || lba == 0 {
+			continue
+		}
+		// the start of the partition is given in sectors, so try every size
+		for ss := MinSectorSize; ss <= maxFATSectorSize; ss *= 2 {
+			if _, err := l.dev.ReadAt(buf, lba*int64(ss)); err == nil && bootSectorSize(buf) == ss {
+				return ss, nil
+			}
+		}
+	}
+	return l.sectorSize, nil
+}
+
+// bootSectorSize returns the sector size recorded in buf, the first 512
+// bytes of a FAT or exFAT boot sector, or zero if buf is not a boot sector
+// or records an unsupported size.
+func bootSectorSize(buf []byte) int {
+	var ss int
+	switch {
+	case string(buf[3:11]) == "EXFAT   ":
+		if buf[108] < 16 {
+			ss = 1 << buf[108]
+		}
+	case buf[510] == 0x55 && buf[511] == 0xAA && (buf[0] == 0xEB || buf[0] == 0xE9 || buf[0] == 0xE8):
+		ss = int(buf[11]) | int(buf[12])<<8
+	}
+	if ss < MinSectorSize || ss > maxFATSectorSize || ss&(ss-1) != 0 {
+		return 0
+	}
+	return ss
+}
+
+// validFormatSize reports whether size is zero, for the default, or a power
+// of two between min and max.
+func validFormatSize(size, min, max int) bool {
+	return size == 0 || size >= min && size <= max && size&(size-1) == 0
+}
+
+// Type returns the type of the mounted volume, or zero if it is not mounted.
+func (l *FATFS) Type() Type {
+	if !l.mounted {
+		return 0
+	}
+	return Type(l.fs.fs_type)
+}
+
+// OpenHandles returns the files and directories that are currently open on
+// the volume, sorted by path.
+func (l *FATFS) OpenHandles() []tinyfs.OpenHandle {
+	files := l.openFiles()
+	handles := make([]tinyfs.OpenHandle, 0, len(files))
+	for _, file := range files {
+		handles = append(handles, tinyfs.OpenHandle{
+			Path:     file.name,
+			Flags:    file.flags,
+			CallSite: file.callSite,
+		})
+	}
+	sort.Slice(handles, func(i, j int) bool {
+		if handles[i].Path != handles[j].Path {
+			return handles[i].Path < handles[j].Path
+		}
+		return handles[i].CallSite < handles[j].CallSite
+	})
+	return handles
+}
+
+// openFiles returns the files and directories that are currently open.
+func (l *FATFS) openFiles() []*File {
+	l.filesMu.Lock()
+	defer l.filesMu.Unlock()
+	files := make([]*File, 0, len(l.files))
+	for file := range l.files {
+		files = append(files, file)
+	}
+	return files
+}
+
+// addFile registers an open file, unless the limit on the number of open
+// files has been reached. It reports whether the file was registered.
+func (l *FATFS) addFile(file *File) bool {
+	l.filesMu.Lock()
+	defer l.filesMu.Unlock()
+	if l.maxOpenFiles > 0 && len(l.files) >= l.maxOpenFiles {
+		return false
+	}
+	if l.files == nil {
+		l.files = make(map[*File]struct{})
+	}
+	l.files[file] = struct{}{}
+	return true
 }
 
+func (l *FATFS) removeFile(file *File) {
+	l.filesMu.Lock()
+	defer l.filesMu.Unlock()
+	delete(l.files, file)
+}
+
+// acquire takes the grant to access the volume, waiting at most lockTimeout
+// for another operation to release it. It reports whether it succeeded.
+func (l *FATFS) acquire() bool {
+	select {
+	case l.grant <- struct{}{}:
+		return true
+	default:
+	}
+	timer := time.NewTimer(l.lockTimeout)
+	defer timer.Stop()
+	select {
+	case l.grant <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// release gives back the grant taken by acquire.
+func (l *FATFS) release() {
+	<-l.grant
+}
+
+// checkMounted returns tinyfs.ErrNotMounted unless the volume is mounted.
+// Close frees the work area of the volume, so nothing else may be passed to
+// FatFs before this check.
+func (l *FATFS) checkMounted() error {
+	if !l.mounted {
+		return tinyfs.ErrNotMounted
+	}
+	return nil
+}
+
+// Free returns the number of bytes available for new data.
 func (l *FATFS) Free() (int64, error) {
+	if err := l.checkMounted(); err != nil {
+		return 0, pathError("free", "/", err)
+	}
 	var clust C.DWORD
 	res := C.f_getfree(l.fs, &clust)
 	if err := errval(res); err != nil {
-		return 0, err
+		return 0, pathError("free", "/", err)
+	}
+	return int64(clust) * l.clusterSize(), nil
+}
+
+// maxFileSize returns the largest file size representable on the mounted
+// volume, which is only limited by int64 on exFAT.
+func (l *FATFS) maxFileSize() int64 {
+	if l.fs.fs_type == C.FS_EXFAT {
+		return math.MaxInt64
 	}
-	return int64(clust * SectorSize), nil
+	return maxFATFileSize
+}
+
+// clusterSize returns the size of a cluster of the mounted volume in bytes.
+func (l *FATFS) clusterSize() int64 {
+	return int64(l.fs.csize) * int64(l.ssize)
+}
+
+var _ tinyfs.StatFS = (*FATFS)(nil)
+
+// StatFS reports the capacity of the data area of the volume in clusters.
+// The space taken up by the FATs and, on FAT12/16, the root directory is not
+// included.
+func (l *FATFS) StatFS() (tinyfs.FSStat, error) {
+	if err := l.checkMounted(); err != nil {
+		return tinyfs.FSStat{}, pathError("statfs", "/", err)
+	}
+	var clust C.DWORD
+	if err := errval(C.f_getfree(l.fs, &clust)); err != nil {
+		return tinyfs.FSStat{}, pathError("statfs", "/", err)
+	}
+	clusterSize := l.clusterSize()
+	total := int64(l.fs.n_fatent-2) * clusterSize
+	free := int64(clust) * clusterSize
+	return tinyfs.FSStat{
+		BlockSize:     clusterSize,
+		TotalBytes:    total,
+		FreeBytes:     free,
+		UsedBytes:     total - free,
+		MaxDirEntries: l.maxDirEntries(),
+		NameMax:       C.FF_MAX_LFN,
+	}, nil
 }
 
+// maxDirEntries returns the number of 32-byte entries that the smallest
+// directory of the volume can hold. The root directory of FAT12 and FAT16 has
+// a fixed size, and FatFs limits the other directories to 2 MiB, or to 256 MiB
+// on exFAT.
+func (l *FATFS) maxDirEntries() int64 {
+	switch l.fs.fs_type {
+	case C.FS_FAT12, C.FS_FAT16:
+		return int64(l.fs.n_rootdir)
+	case C.FS_EXFAT:
+		return 0x10000000 / 32
+	default:
+		return 0x200000 / 32
+	}
+}
+
+// Unmount unmounts the volume. It fails with tinyfs.ErrBusy if files or
+// directories are still open; OpenHandles lists them. Unmounting a volume
+// that is not mounted does nothing.
 func (l *FATFS) Unmount() error {
-	return nil
+	if !l.mounted {
+		return nil
+	}
+	if len(l.openFiles()) > 0 {
+		return pathError("unmount", "/", tinyfs.ErrBusy)
+	}
+	l.mounted = false
+	return pathError("unmount", "/", errval(C.f_umount(l.fs)))
 }
 
+// Close closes all files and directories that are still open, unmounts the
+// volume if it is mounted and frees the memory that was allocated by
+// Configure. Operations on the files that were closed fail with an error
+// matching fs.ErrClosed. The filesystem cannot be used again until it is
+// configured anew.
+func (l *FATFS) Close() error {
+	var err error
+	for _, file := range l.openFiles() {
+		if cerr := file.Close(); err == nil {
+			err = pathError("close", file.name, cerr)
+		}
+	}
+	if uerr := l.Unmount(); err == nil {
+		err = uerr
+	}
+	if l.fs != nil {
+		gopointer.Unref(l.fs.drv)
+		C.free(unsafe.Pointer(l.fs))
+		l.fs = nil
+	}
+	return err
+}
+
+// Remove removes the named file or empty directory. Removing a directory
+// that is not empty fails with FileResultNotEmpty.
 func (l *FATFS) Remove(path string) error {
+	if err := l.checkMounted(); err != nil {
+		return pathError("remove", path, err)
+	}
 	cs := cstring(path)
 	defer C.free(unsafe.Pointer(cs))
-	return errval(C.f_unlink(l.fs, cs))
+	errno := C.f_unlink(l.fs, cs)
+	if errno == C.FR_DENIED && l.attrs(cs)&AttrDirectory != 0 && !l.emptyDir(cs) {
+		// FatFs reports non-empty directories like read-only ones
+		return pathError("remove", path, FileResultNotEmpty)
+	}
+	return pathError("remove", path, errval(errno))
 }
 
+// Rename renames a file or directory. Like os.Rename, it replaces a file that
+// exists at newPath with a file, but unlike it, it does so in several steps,
+// as FatFs cannot replace files: the existing file is renamed aside, the file
+// is renamed to newPath and the old one is removed. If the second step fails,
+// the existing file is put back. A power cut between the steps does not lose
+// either file, but may leave the replaced one behind next to newPath, under
+// the name newPath.~N.
 func (l *FATFS) Rename(oldPath string, newPath string) error {
+	if err := l.checkMounted(); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: err}
+	}
 	cs1, cs2 := cstring(oldPath), cstring(newPath)
 	defer C.free(unsafe.Pointer(cs1))
 	defer C.free(unsafe.Pointer(cs2))
-	return errval(C.f_rename(l.fs, cs1, cs2))
+	errno := C.f_rename(l.fs, cs1, cs2)
+	if errno == C.FR_EXIST && l.attrs(cs1)&AttrDirectory == 0 && l.attrs(cs2)&AttrDirectory == 0 {
+		errno = l.replace(cs1, cs2, newPath)
+	}
+	if err := errval(errno); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: err}
+	}
+	return nil
+}
+
+// replace renames the file cs1 over the existing file cs2, named newPath.
+// Each FatFs call takes the grant on its own, so another goroutine may create
+// newPath after it has been renamed aside. The final rename then fails, and
+// the replaced file is restored rather than lost.
+func (l *FATFS) replace(cs1, cs2 *C.char, newPath string) C.FRESULT {
+	var aside *C.char
+	errno := C.FRESULT(C.FR_EXIST)
+	for i := 0; errno == C.FR_EXIST; i++ {
+		C.free(unsafe.Pointer(aside))
+		aside = cstring(fmt.Sprintf("%s.~%d", newPath, i))
+		errno = C.f_rename(l.fs, cs2, aside)
+	}
+	defer C.free(unsafe.Pointer(aside))
+	if errno != C.FR_OK {
+		return errno
+	}
+	if errno = C.f_rename(l.fs, cs1, cs2); errno != C.FR_OK {
+		C.f_rename(l.fs, aside, cs2)
+		return errno
+	}
+	// the rename is done even if this fails, which leaves the replaced file
+	// behind under its temporary name
+	C.f_unlink(l.fs, aside)
+	return C.FR_OK
+}
+
+// attrs returns the attributes of the named file or directory, or zero if it
+// cannot be found.
+func (l *FATFS) attrs(cs *C.char) FileAttr {
+	info := C.FILINFO{}
+	if C.f_stat(l.fs, cs, &info) != C.FR_OK {
+		return 0
+	}
+	return FileAttr(info.fattrib)
+}
+
+// emptyDir reports whether the named directory has no entries.
+func (l *FATFS) emptyDir(cs *C.char) bool {
+	dir := C.go_fatfs_new_ff_dir()
+	defer C.free(unsafe.Pointer(dir))
+	if C.f_opendir(l.fs, dir, cs) != C.FR_OK {
+		return false
+	}
+	defer C.f_closedir(dir)
+	info := C.FILINFO{}
+	return C.f_readdir(dir, &info) == C.FR_OK && info.fname[0] == 0
 }
 
 func (l *FATFS) Stat(path string) (os.FileInfo, error) {
+	if err := l.checkMounted(); err != nil {
+		return nil, pathError("stat", path, err)
+	}
 	cs := cstring(path)
 	defer C.free(unsafe.Pointer(cs))
 	info := C.FILINFO{}
 	if err := errval(C.f_stat(l.fs, cs, &info)); err != nil {
-		return nil, err
+		return nil, pathError("stat", path, err)
 	}
-	return &Info{
-		size: int64(info.fsize),
-		name: gostring(&info.fname[0]),
-		attr: FileAttr(info.fattrib),
-	}, nil
+	return newInfo(&info, gostring(&info.fname[0])), nil
+}
+
+// Chtimes changes the modification time of the named file or directory. FAT
+// only records the date of the last access, so atime is ignored.
+func (l *FATFS) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	if err := l.checkMounted(); err != nil {
+		return pathError("chtimes", path, err)
+	}
+	t, ok := encodeFATTime(mtime)
+	if !ok {
+		return pathError("chtimes", path, FileResultInvalidParameter)
+	}
+	cs := cstring(path)
+	defer C.free(unsafe.Pointer(cs))
+	info := C.FILINFO{
+		fdate: C.WORD(t >> 16),
+		ftime: C.WORD(t),
+	}
+	return pathError("chtimes", path, errval(C.f_utime(l.fs, cs, &info)))
 }
 
 func (l *FATFS) Mkdir(path string, _ os.FileMode) error {
+	if err := l.checkMounted(); err != nil {
+		return pathError("mkdir", path, err)
+	}
 	cs := cstring(path)
 	defer C.free(unsafe.Pointer(cs))
-	return errval(C.f_mkdir(l.fs, cs))
+	return pathError("mkdir", path, errval(C.f_mkdir(l.fs, cs)))
 }
 
 func (l *FATFS) Open(path string) (tinyfs.File, error) {
@@ -251,6 +886,15 @@ func (l *FATFS) Open(path string) (tinyfs.File, error) {
 
 func (l *FATFS) OpenFile(path string, flags int) (tinyfs.File, error) {
 
+	// validate the flags before touching the filesystem
+	mode, err := translateFlags(flags)
+	if err != nil {
+		return nil, pathError("open", path, err)
+	}
+	if err := l.checkMounted(); err != nil {
+		return nil, pathError("open", path, err)
+	}
+
 	// create a C string with the file path
 	cs := cstring(path)
 	defer C.free(unsafe.Pointer(cs))
@@ -259,13 +903,23 @@ func (l *FATFS) OpenFile(path string, flags int) (tinyfs.File, error) {
 	info := &C.FILINFO{}
 	if err := errval(C.f_stat(l.fs, cs, info)); err != nil && err != FileResultNoFile && err != FileResultInvalidName {
 		//println("warning:", err)
-		return nil, err
+		return nil, pathError("open", path, err)
+	}
+
+	// directories can only be opened for reading
+	isDir := path == "/" || info.fattrib&C.AM_DIR > 0
+	if isDir && flags&(os.O_WRONLY|os.O_RDWR|os.O_TRUNC) != 0 {
+		return nil, pathError("open", path, FileResultDenied)
 	}
 
 	// use f_open or f_opendir to obtain a handle to the object
-	var file = &File{fs: l, name: path}
+	var file = &File{fs: l, name: path, flags: flags}
+	file.callSite = util.CallSite("tinygo.org/x/tinyfs/fatfs.(*FATFS).")
+	if !l.addFile(file) {
+		return nil, pathError("open", path, FileResultTooManyOpenFiles)
+	}
 	var errno C.FRESULT
-	if path == "/" || info.fattrib&C.AM_DIR > 0 {
+	if isDir {
 		// directory
 		file.typ = uint8(C.AM_DIR)
 		file.hndl = unsafe.Pointer(C.go_fatfs_new_ff_dir())
@@ -274,7 +928,10 @@ func (l *FATFS) OpenFile(path string, flags int) (tinyfs.File, error) {
 		// file
 		file.typ = 0
 		file.hndl = unsafe.Pointer(C.go_fatfs_new_fil())
-		errno = C.f_open(l.fs, (*C.FIL)(file.hndl), cs, translateFlags(flags))
+		errno = C.f_open(l.fs, (*C.FIL)(file.hndl), cs, mode)
+		if errno == C.FR_OK {
+			errno = file.applyFlags(mode)
+		}
 	}
 
 	// check to make sure f_open/f_opendir didn't produce an error
@@ -283,7 +940,8 @@ func (l *FATFS) OpenFile(path string, flags int) (tinyfs.File, error) {
 			C.free(file.hndl)
 			file.hndl = nil
 		}
-		return nil, err
+		l.removeFile(file)
+		return nil, pathError("open", path, err)
 	}
 
 	// file handle was initialized successfully
@@ -292,38 +950,88 @@ func (l *FATFS) OpenFile(path string, flags int) (tinyfs.File, error) {
 
 // translateFlags translates osFlags such as os.O_RDONLY into fatfs flags.
 // http://elm-chan.org/fsw/ff/doc/open.html
-func translateFlags(osFlags int) C.BYTE {
+//
+// FatFs has no direct equivalent of os.O_TRUNC without os.O_CREATE, nor of
+// os.O_APPEND and os.O_SYNC; OpenFile and File emulate those. Combinations
+// that make no sense, such as os.O_TRUNC with os.O_RDONLY, are rejected.
+func translateFlags(osFlags int) (C.BYTE, error) {
+	if osFlags&^(accessModeMask|os.O_APPEND|os.O_CREATE|os.O_EXCL|os.O_SYNC|os.O_TRUNC) != 0 {
+		return 0, FileResultInvalidParameter
+	}
 	var result C.BYTE
-	result = C.FA_READ
-	switch osFlags {
+	switch osFlags & accessModeMask {
 	case os.O_RDONLY:
-		// r
+		if osFlags&os.O_TRUNC != 0 {
+			return 0, FileResultInvalidParameter
+		}
 		result = C.FA_READ
-	case os.O_WRONLY | os.O_CREATE | os.O_TRUNC:
-		// w
-		result = C.FA_CREATE_ALWAYS | C.FA_WRITE
-	case os.O_WRONLY | os.O_CREATE | os.O_APPEND:
-		// a
-		result = C.FA_OPEN_APPEND | C.FA_WRITE
+	case os.O_WRONLY:
+		result = C.FA_WRITE
 	case os.O_RDWR:
-		// r+
 		result = C.FA_READ | C.FA_WRITE
-	case os.O_RDWR | os.O_CREATE | os.O_TRUNC:
-		// w+
-		result = C.FA_CREATE_ALWAYS | C.FA_WRITE | C.FA_READ
-	case os.O_RDWR | os.O_CREATE | os.O_APPEND:
-		// a+
-		result = C.FA_OPEN_APPEND | C.FA_WRITE | C.FA_READ
 	default:
+		return 0, FileResultInvalidParameter
 	}
-	return result
+	switch {
+	case osFlags&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		result |= C.FA_CREATE_NEW
+	case osFlags&(os.O_CREATE|os.O_TRUNC) == os.O_CREATE|os.O_TRUNC:
+		result |= C.FA_CREATE_ALWAYS
+	case osFlags&os.O_CREATE != 0:
+		result |= C.FA_OPEN_ALWAYS
+	default:
+		result |= C.FA_OPEN_EXISTING
+	}
+	return result, nil
 }
 
+var _ tinyfs.File = (*File)(nil)
+
 type File struct {
-	fs   *FATFS
-	typ  uint8
-	hndl unsafe.Pointer
-	name string
+	fs    *FATFS
+	typ   uint8
+	hndl  unsafe.Pointer
+	name  string
+	flags int
+
+	// callSite is where the file was opened, for OpenHandles
+	callSite string
+
+	// pastEOF is the position of a read-only file that was seeked past its
+	// end, which FatFs cannot represent, or zero
+	pastEOF int64
+
+	// mu serializes the operations on the file in thread-safe mode
+	mu sync.Mutex
+}
+
+// applyFlags emulates the open flags that FatFs does not support natively on a
+// freshly opened file; on failure the file is closed again.
+func (f *File) applyFlags(mode C.BYTE) (errno C.FRESULT) {
+	ptr := f.fileptr()
+	if f.flags&os.O_TRUNC != 0 && mode&C.FA_CREATE_ALWAYS == 0 {
+		// an existing file is truncated without being re-created
+		errno = C.f_truncate(ptr)
+	}
+	if errno == C.FR_OK && f.flags&os.O_APPEND != 0 {
+		errno = C.f_lseek(ptr, ptr.obj.objsize)
+	}
+	if errno != C.FR_OK {
+		C.f_close(ptr)
+	}
+	return errno
+}
+
+func (f *File) lock() {
+	if f.fs.grant != nil {
+		f.mu.Lock()
+	}
+}
+
+func (f *File) unlock() {
+	if f.fs.grant != nil {
+		f.mu.Unlock()
+	}
 }
 
 func (f *File) dirptr() *C.FF_DIR {
@@ -340,11 +1048,15 @@ func (f *File) Name() string {
 }
 
 func (f *File) Close() error {
+	f.lock()
+	defer f.unlock()
 	var errno C.FRESULT
 	if f.hndl != nil {
 		defer func() {
 			C.free(f.hndl)
 			f.hndl = nil
+			f.fs.locks.Unlock(lockKey(f.name), f)
+			f.fs.removeFile(f)
 		}()
 		if f.IsDir() {
 			errno = C.f_closedir(f.dirptr())
@@ -355,10 +1067,82 @@ func (f *File) Close() error {
 	return errval(errno)
 }
 
+var _ tinyfs.Locker = (*File)(nil)
+
+// Lock places an advisory lock on the file, as described by tinyfs.Locker.
+// Locks are identified by the path the file was opened with, ignoring case
+// like FAT does, so they do not follow the file when it is renamed. They are
+// independent of the FatFs sharing policy enabled by FF_FS_LOCK.
+func (f *File) Lock(typ tinyfs.LockType) error {
+	return f.flock(typ, true)
+}
+
+// TryLock places an advisory lock on the file like Lock, but fails with
+// FileResultLocked instead of waiting for a conflicting lock.
+func (f *File) TryLock(typ tinyfs.LockType) error {
+	return f.flock(typ, false)
+}
+
+// Unlock releases the advisory lock held through the file, if any.
+func (f *File) Unlock() error {
+	f.lock()
+	defer f.unlock()
+	if f.hndl == nil {
+		return FileResultInvalidObject
+	}
+	f.fs.locks.Unlock(lockKey(f.name), f)
+	return nil
+}
+
+// flock takes an advisory lock of the given type. The file itself is not
+// locked while waiting, so that it can still be used and closed meanwhile.
+func (f *File) flock(typ tinyfs.LockType, wait bool) error {
+	if typ != tinyfs.LockShared && typ != tinyfs.LockExclusive {
+		return FileResultInvalidParameter
+	}
+	if f.closed() {
+		return FileResultInvalidObject
+	}
+	key := lockKey(f.name)
+	if !f.fs.locks.Lock(key, f, typ == tinyfs.LockExclusive, wait) {
+		return FileResultLocked
+	}
+	if f.closed() {
+		// closed while waiting, after Close released the locks of the file
+		f.fs.locks.Unlock(key, f)
+		return FileResultInvalidObject
+	}
+	return nil
+}
+
+func (f *File) closed() bool {
+	f.lock()
+	defer f.unlock()
+	return f.hndl == nil
+}
+
+// lockKey returns the key identifying a file in the lock table, which is the
+// same for every way of spelling its path.
+func lockKey(name string) string {
+	return strings.ToUpper(path.Clean("/" + name))
+}
+
 func (f *File) Read(buf []byte) (n int, err error) {
+	f.lock()
+	defer f.unlock()
+	return f.read(buf)
+}
+
+func (f *File) read(buf []byte) (n int, err error) {
+	if f.hndl == nil {
+		return 0, FileResultInvalidObject
+	}
 	if f.IsDir() {
 		return 0, FileResultInvalidObject
 	}
+	if len(buf) == 0 {
+		return 0, nil
+	}
 	bufptr := unsafe.Pointer(&buf[0])
 	var br, btr C.UINT = 0, C.UINT(len(buf))
 	errno := C.f_read(f.fileptr(), bufptr, btr, &br)
@@ -371,33 +1155,168 @@ func (f *File) Read(buf []byte) (n int, err error) {
 	return int(br), nil
 }
 
-/*
-// Seek changes the position of the file
+// ReadAt reads len(buf) bytes from the file starting at byte offset off. The
+// current position of the file is not affected.
+func (f *File) ReadAt(buf []byte, off int64) (n int, err error) {
+	f.lock()
+	defer f.unlock()
+	if f.hndl == nil {
+		return 0, FileResultInvalidObject
+	}
+	if f.IsDir() {
+		return 0, FileResultInvalidObject
+	}
+	if off < 0 {
+		return 0, FileResultInvalidParameter
+	}
+	ptr := f.fileptr()
+	if off >= int64(ptr.obj.objsize) {
+		// seeking here would extend a writable file
+		if len(buf) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	pos := int64(ptr.fptr)
+	defer func() {
+		if serr := errval(C.f_lseek(ptr, C.FSIZE_t(pos))); err == nil {
+			err = serr
+		}
+	}()
+	if err := errval(C.f_lseek(ptr, C.FSIZE_t(off))); err != nil {
+		return 0, err
+	}
+	for n < len(buf) {
+		m, err := f.read(buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Seek changes the position of the file. Seeking past the end of a file that
+// was opened for writing extends it, filling the gap with zeros. A read-only
+// file keeps its size, and reading at such a position returns io.EOF.
 func (f *File) Seek(offset int64, whence int) (ret int64, err error) {
-	errno := C.int(C.lfs_file_seek(f.lfs.lfs, &f.fptr, C.lfs_soff_t(offset), C.int(whence)))
-	if errno < 0 {
-		return -1, errval(errno)
+	f.lock()
+	defer f.unlock()
+	return f.seek(offset, whence)
+}
+
+func (f *File) seek(offset int64, whence int) (ret int64, err error) {
+	if f.hndl == nil {
+		return -1, FileResultInvalidObject
 	}
-	return int64(errno), nil
+	if f.IsDir() {
+		return -1, FileResultInvalidObject
+	}
+	ptr := f.fileptr()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.position()
+	case io.SeekEnd:
+		offset += int64(ptr.obj.objsize)
+	default:
+		return -1, FileResultInvalidParameter
+	}
+	if offset < 0 || offset > f.fs.maxFileSize() {
+		return -1, FileResultInvalidParameter
+	}
+	f.pastEOF = 0
+	if size := int64(ptr.obj.objsize); offset > size {
+		if ptr.flag&C.FA_WRITE == 0 {
+			// f_lseek would stop at the end of the file
+			if err := errval(C.f_lseek(ptr, C.FSIZE_t(size))); err != nil {
+				return -1, err
+			}
+			f.pastEOF = offset
+			return offset, nil
+		}
+		if err := f.extend(size, offset); err != nil {
+			return -1, err
+		}
+	}
+	if err := errval(C.f_lseek(ptr, C.FSIZE_t(offset))); err != nil {
+		return -1, err
+	}
+	return int64(ptr.fptr), nil
+}
+
+// position returns the position of the file, including one past its end.
+func (f *File) position() int64 {
+	if f.pastEOF != 0 {
+		return f.pastEOF
+	}
+	return int64(f.fileptr().fptr)
 }
 
 // Tell returns the position of the file
 func (f *File) Tell() (ret int64, err error) {
-	errno := C.int(C.lfs_file_tell(f.lfs.lfs, &f.fptr))
-	if errno < 0 {
-		return -1, errval(errno)
+	f.lock()
+	defer f.unlock()
+	return f.tell()
+}
+
+func (f *File) tell() (ret int64, err error) {
+	if f.hndl == nil {
+		return -1, FileResultInvalidObject
+	}
+	if f.IsDir() {
+		return -1, FileResultInvalidObject
 	}
-	return int64(errno), nil
+	return f.position(), nil
 }
 
 // Rewind changes the position of the file to the beginning of the file
 func (f *File) Rewind() (err error) {
-	return errval(C.lfs_file_rewind(f.lfs.lfs, &f.fptr))
+	f.lock()
+	defer f.unlock()
+	if f.hndl == nil {
+		return FileResultInvalidObject
+	}
+	if f.IsDir() {
+		return FileResultInvalidObject
+	}
+	f.pastEOF = 0
+	return errval(C.f_lseek(f.fileptr(), 0))
+}
+
+// extend grows the file from size to newSize bytes by writing zeros at its
+// end; FatFs would otherwise leave whatever was on the disk in the new area.
+func (f *File) extend(size int64, newSize int64) error {
+	ptr := f.fileptr()
+	if err := errval(C.f_lseek(ptr, C.FSIZE_t(size))); err != nil {
+		return err
+	}
+	zeros := make([]byte, extendChunkSize)
+	for size < newSize {
+		b := zeros
+		if int64(len(b)) > newSize-size {
+			b = b[:newSize-size]
+		}
+		n, err := f.write(b)
+		size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
-*/
 
 // Size returns the size of the file
 func (f *File) Size() (int64, error) {
+	f.lock()
+	defer f.unlock()
+	return f.size()
+}
+
+func (f *File) size() (int64, error) {
+	if f.hndl == nil {
+		return -1, FileResultInvalidObject
+	}
 	if f.IsDir() {
 		ptr := f.dirptr()
 		return int64(ptr.obj.objsize), nil
@@ -407,27 +1326,111 @@ func (f *File) Size() (int64, error) {
 	}
 }
 
+// Stat returns the FileInfo structure describing the open file. The
+// modification time is read from the directory entry, so it only reflects
+// writes that have been synchronized.
+func (f *File) Stat() (os.FileInfo, error) {
+	f.lock()
+	defer f.unlock()
+	if f.hndl == nil {
+		return nil, FileResultInvalidObject
+	}
+	info := &Info{name: path.Base(f.name)}
+	if f.IsDir() {
+		info.attr = AttrDirectory
+	} else {
+		ptr := f.fileptr()
+		info.size = int64(ptr.obj.objsize)
+		info.attr = FileAttr(ptr.obj.attr)
+	}
+	if f.name != "/" {
+		if fi, err := f.fs.Stat(f.name); err == nil {
+			info.mtime = fi.ModTime()
+		}
+	}
+	return info, nil
+}
+
 // Synchronize a file on storage
 //
 // Any pending writes are written out to storage.
 // Returns a negative error code on failure.
 func (f *File) Sync() error {
+	f.lock()
+	defer f.unlock()
+	return f.sync()
+}
+
+func (f *File) sync() error {
+	if f.hndl == nil {
+		return FileResultInvalidObject
+	}
+	if f.IsDir() {
+		return nil
+	}
 	return errval(C.f_sync(f.fileptr()))
 }
 
-/*
-// Truncates the size of the file to the specified size
-//
-// Returns a negative error code on failure.
-func (f *File) Truncate(size uint32) error {
-	return errval(C.lfs_file_truncate(f.lfs.lfs, &f.fptr, C.lfs_off_t(size)))
+// Truncate the size of the file to the specified size. Growing the file fills
+// the new area with zeros. The position of the file is left unchanged unless
+// it would be past the new end of the file, in which case it is moved there.
+func (f *File) Truncate(size int64) error {
+	f.lock()
+	defer f.unlock()
+	if f.hndl == nil {
+		return FileResultInvalidObject
+	}
+	if f.IsDir() {
+		return FileResultInvalidObject
+	}
+	if size < 0 || size > f.fs.maxFileSize() {
+		return FileResultInvalidParameter
+	}
+	ptr := f.fileptr()
+	if ptr.flag&C.FA_WRITE == 0 {
+		return FileResultDenied
+	}
+	pos, cur := int64(ptr.fptr), int64(ptr.obj.objsize)
+	if size > cur {
+		if err := f.extend(cur, size); err != nil {
+			return err
+		}
+	} else {
+		if err := errval(C.f_lseek(ptr, C.FSIZE_t(size))); err != nil {
+			return err
+		}
+		if err := errval(C.f_truncate(ptr)); err != nil {
+			return err
+		}
+	}
+	if pos > size {
+		pos = size
+	}
+	return errval(C.f_lseek(ptr, C.FSIZE_t(pos)))
 }
-*/
 
 func (f *File) Write(buf []byte) (n int, err error) {
+	f.lock()
+	defer f.unlock()
+	return f.write(buf)
+}
+
+func (f *File) write(buf []byte) (n int, err error) {
+	if f.hndl == nil {
+		return 0, FileResultInvalidObject
+	}
 	if f.IsDir() {
 		return 0, FileResultInvalidObject
 	}
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	if f.flags&os.O_APPEND != 0 {
+		ptr := f.fileptr()
+		if err := errval(C.f_lseek(ptr, ptr.obj.objsize)); err != nil {
+			return 0, err
+		}
+	}
 	bufptr := unsafe.Pointer(&buf[0])
 	var bw, btw C.UINT = 0, C.UINT(len(buf))
 	errno := C.f_write(f.fileptr(), bufptr, btw, &bw)
@@ -435,40 +1438,134 @@ func (f *File) Write(buf []byte) (n int, err error) {
 		return int(bw), err
 	}
 	if bw < btw {
-		return int(bw), errors.New("volume is full")
+		return int(bw), pathError("write", f.name, tinyfs.ErrNoSpace)
+	}
+	if f.flags&os.O_SYNC != 0 {
+		if err := f.sync(); err != nil {
+			return int(bw), err
+		}
 	}
 	return int(bw), nil
 }
 
+// WriteAt writes len(buf) bytes to the file starting at byte offset off. The
+// current position of the file is not affected. Writing past the end of the
+// file extends it, filling any gap with zeros. WriteAt is not permitted on
+// files opened with os.O_APPEND.
+func (f *File) WriteAt(buf []byte, off int64) (n int, err error) {
+	f.lock()
+	defer f.unlock()
+	if f.hndl == nil {
+		return 0, FileResultInvalidObject
+	}
+	if f.IsDir() {
+		return 0, FileResultInvalidObject
+	}
+	if off < 0 {
+		return 0, FileResultInvalidParameter
+	}
+	if f.flags&os.O_APPEND != 0 {
+		return 0, errors.New("fatfs: WriteAt not permitted on file opened with O_APPEND")
+	}
+	pos, err := f.tell()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if _, serr := f.seek(pos, io.SeekStart); err == nil {
+			err = serr
+		}
+	}()
+	if _, err := f.seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	for n < len(buf) {
+		m, err := f.write(buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
 func (f *File) IsDir() bool {
 	return f.typ == C.AM_DIR
 }
 
+// Readdir reads the contents of the directory and returns up to n FileInfo
+// values, in directory order, like os.File.Readdir. If n > 0, it returns an
+// empty slice and io.EOF once the end of the directory is reached. If n <= 0,
+// it returns all remaining entries and a nil error at the end.
 func (f *File) Readdir(n int) (infos []os.FileInfo, err error) {
-	if !f.IsDir() {
-		return nil, FileResultInvalidObject
+	f.lock()
+	defer f.unlock()
+	err = f.readdir(n, func(info *Info) {
+		infos = append(infos, info)
+	})
+	return infos, err
+}
+
+// ReadDir reads the contents of the directory like Readdir, but returns
+// fs.DirEntry values.
+func (f *File) ReadDir(n int) (entries []fs.DirEntry, err error) {
+	f.lock()
+	defer f.unlock()
+	err = f.readdir(n, func(info *Info) {
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	})
+	return entries, err
+}
+
+// readdir calls fn for up to n entries of the directory, or for all remaining
+// entries if n <= 0.
+func (f *File) readdir(n int, fn func(info *Info)) error {
+	if f.hndl == nil {
+		return FileResultInvalidObject
 	}
-	if n == 0 {
-		// passing nil pointer to f_readdir resets the read index
-		if err := errval(C.f_readdir(f.dirptr(), nil)); err != nil {
-			return nil, err
-		}
+	if !f.IsDir() {
+		return FileResultInvalidObject
 	}
-	for {
+	count := 0
+	for n <= 0 || count < n {
 		info := C.FILINFO{}
 		if err := errval(C.f_readdir(f.dirptr(), &info)); err != nil {
-			return nil, err
+			return err
 		}
-		if fname := gostring(&info.fname[0]); fname == "" {
-			return infos, nil
-		} else {
-			infos = append(infos, &Info{
-				size: int64(info.fsize),
-				name: fname,
-				attr: FileAttr(info.fattrib),
-			})
+		fname := gostring(&info.fname[0])
+		if fname == "" {
+			break
 		}
+		fn(newInfo(&info, fname))
+		count++
+	}
+	if n > 0 && count == 0 {
+		return io.EOF
+	}
+	return nil
+}
+
+// RewindDir changes the position in the directory listing to its beginning.
+func (f *File) RewindDir() error {
+	f.lock()
+	defer f.unlock()
+	if f.hndl == nil {
+		return FileResultInvalidObject
+	}
+	if !f.IsDir() {
+		return FileResultInvalidObject
+	}
+	// passing nil pointer to f_readdir resets the read index
+	return errval(C.f_readdir(f.dirptr(), nil))
+}
+
+// pathError wraps a non-nil err in an *os.PathError recording the operation
+// and the path it was performed on.
+func pathError(op string, path string, err error) error {
+	if err == nil {
+		return nil
 	}
+	return &os.PathError{Op: op, Path: path, Err: err}
 }
 
 func cstring(s string) *C.char {
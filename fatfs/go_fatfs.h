@@ -8,7 +8,10 @@ extern DRESULT go_fatfs_disk_read(void* drv, void* buff, DWORD sector, UINT coun
 extern DRESULT go_fatfs_disk_write(void* drv, void* buff, DWORD sector, UINT count);
 extern DRESULT go_fatfs_disk_ioctl(void* drv, BYTE cmd, DWORD* param);
 
-extern DWORD go_fatfs_get_fattime();
+extern DWORD go_fatfs_get_fattime(void* drv);
+
+extern int go_fatfs_req_grant(void* sobj);
+extern void go_fatfs_rel_grant(void* sobj);
 
 // Helper functions used to allocate new FatFs objects, needed because TinyGo
 // does not support sizeof() yet
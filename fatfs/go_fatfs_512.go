@@ -0,0 +1,11 @@
+//go:build fatfs_512
+
+package fatfs
+
+// The fatfs_512 build tag lowers FF_MAX_SS to 512 for boards that only use
+// 512-byte sectors. The work area of every volume and the buffer of every open
+// file then take 512 bytes instead of 4096, and volumes with larger sectors
+// fail to mount with an error matching FileResultInvalidParameter.
+
+// #cgo CFLAGS: -DFF_MAX_SS=512
+import "C"
@@ -5,6 +5,7 @@ package fatfs
 import "C"
 
 import (
+	"math"
 	"time"
 	"unsafe"
 
@@ -21,12 +22,11 @@ func go_fatfs_disk_read(drv unsafe.Pointer, bufptr unsafe.Pointer, sector uint32
 	if debug {
 		println("disk_read:", sector, count)
 	}
-	bdev := restore(drv).dev
-	sect := int(SectorSize)
-	size := sect * int(count)
-	addr := int64(sector) * int64(sect)
+	l := restore(drv)
+	size := l.ssize * int(count)
+	addr := int64(sector) * int64(l.ssize)
 	buffer := (*[1 << 28]byte)(bufptr)[:size:size]
-	if _, err := bdev.ReadAt(buffer, addr); err != nil {
+	if _, err := l.dev.ReadAt(buffer, addr); err != nil {
 		//println("disk_read error:", err)
 		return C.RES_ERROR
 	}
@@ -36,13 +36,12 @@ func go_fatfs_disk_read(drv unsafe.Pointer, bufptr unsafe.Pointer, sector uint32
 //export go_fatfs_disk_write
 func go_fatfs_disk_write(drv unsafe.Pointer, bufptr unsafe.Pointer, sector uint32, count uint) int {
 	//println("disk_write:", sector, count)
-	bdev := restore(drv).dev
-	sect := int(SectorSize)
-	size := sect * int(count)
-	addr := int64(sector) * int64(sect)
+	l := restore(drv)
+	size := l.ssize * int(count)
+	addr := int64(sector) * int64(l.ssize)
 	buffer := (*[1 << 28]byte)(bufptr)[:size:size]
 	//xxdfprint(os.Stdout, 0, buffer)
-	if _, err := bdev.WriteAt(buffer, addr); err != nil {
+	if _, err := l.dev.WriteAt(buffer, addr); err != nil {
 		//println("disk_write error:", err)
 		return C.RES_ERROR
 	}
@@ -52,7 +51,8 @@ func go_fatfs_disk_write(drv unsafe.Pointer, bufptr unsafe.Pointer, sector uint3
 //export go_fatfs_disk_ioctl
 func go_fatfs_disk_ioctl(drv unsafe.Pointer, cmd uint8, param unsafe.Pointer) int {
 	//println("disk_ioctl:", cmd)
-	bdev := restore(drv).dev
+	l := restore(drv)
+	bdev := l.dev
 	switch cmd {
 	case C.CTRL_SYNC:
 		// Complete pending write process (needed at _FS_READONLY == 0)
@@ -63,14 +63,20 @@ func go_fatfs_disk_ioctl(drv unsafe.Pointer, cmd uint8, param unsafe.Pointer) in
 		}
 	case C.GET_SECTOR_COUNT:
 		// Get media size (needed at _USE_MKFS == 1)
-		*((*C.DWORD)(param)) = C.DWORD(bdev.Size() / SectorSize)
+		// FatFs R0.13c has no FF_LBA64 and numbers sectors with a DWORD, so
+		// larger media are truncated to the sectors it can address
+		count := bdev.Size() / int64(l.ssize)
+		if count > math.MaxUint32 {
+			count = math.MaxUint32
+		}
+		*((*C.DWORD)(param)) = C.DWORD(count)
 	case C.GET_SECTOR_SIZE:
 		// Get sector size (needed at _MAX_SS != _MIN_SS)
-		*((*C.WORD)(param)) = C.WORD(SectorSize)
+		*((*C.WORD)(param)) = C.WORD(l.ssize)
 	case C.GET_BLOCK_SIZE:
 		// Get erase block size (needed at _USE_MKFS == 1)
 		// FIXME: not really sure why this doesn't work
-		*((*C.DWORD)(param)) = C.DWORD(bdev.EraseBlockSize() / SectorSize)
+		*((*C.DWORD)(param)) = C.DWORD(bdev.EraseBlockSize() / int64(l.ssize))
 	case C.IOCTL_INIT:
 		// FIXME: not really sure what this would be used for
 		*((*C.DSTATUS)(param)) = C.DSTATUS(0)
@@ -82,19 +88,29 @@ func go_fatfs_disk_ioctl(drv unsafe.Pointer, cmd uint8, param unsafe.Pointer) in
 }
 
 //export go_fatfs_get_fattime
-func go_fatfs_get_fattime() (t uint32) {
-	now := time.Now()
-	year, month, day := now.Date()
-	hour, minute, second := now.Hour(), now.Minute(), now.Second()
-	t |= uint32(year-1980) << 24
-	t |= (uint32(month) & 0xF) << 20
-	t |= (uint32(day) & 0x1F) << 15
-	t |= (uint32(hour) & 0x1F) << 10
-	t |= (uint32(minute) & 0x3F) << 4
-	t |= (uint32(second) / 2) & 0xF
+func go_fatfs_get_fattime(drv unsafe.Pointer) uint32 {
+	if clock := restore(drv).clock; clock != nil {
+		if t, ok := encodeFATTime(clock()); ok {
+			return t
+		}
+	}
+	t, _ := encodeFATTime(time.Date(C.FF_NORTC_YEAR, C.FF_NORTC_MON, C.FF_NORTC_MDAY, 0, 0, 0, 0, time.Local))
 	return t
 }
 
 func restore(ptr unsafe.Pointer) *FATFS {
 	return gopointer.Restore(ptr).(*FATFS)
 }
+
+//export go_fatfs_req_grant
+func go_fatfs_req_grant(sobj unsafe.Pointer) int {
+	if restore(sobj).acquire() {
+		return 1
+	}
+	return 0
+}
+
+//export go_fatfs_rel_grant
+func go_fatfs_rel_grant(sobj unsafe.Pointer) {
+	restore(sobj).release()
+}
@@ -0,0 +1,12 @@
+//go:build fatfs_lock
+
+package fatfs
+
+// The fatfs_lock build tag enables the file sharing policy of FatFs: a file
+// that is open for writing cannot be opened again, and an open file or
+// directory cannot be removed or renamed. Such operations fail with
+// FileResultLocked, which matches tinyfs.ErrLocked. At most FF_FS_LOCK files
+// and directories can then be open on all volumes together.
+
+// #cgo CFLAGS: -DFF_FS_LOCK=16
+import "C"
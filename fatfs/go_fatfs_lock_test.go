@@ -0,0 +1,50 @@
+//go:build fatfs_lock
+
+package fatfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+
+	"tinygo.org/x/tinyfs"
+	"tinygo.org/x/tinyfs/internal/testutil"
+)
+
+func TestSharingPolicy(t *testing.T) {
+	fatfs, _, unmount := createTestFS(t, defaultConfig)
+	defer unmount()
+
+	w, err := fatfs.OpenFile("/shared.txt", os.O_RDWR|os.O_CREATE)
+	check(t, err)
+
+	// a file open for writing cannot be opened again, removed or renamed
+	_, err = fatfs.Open("/shared.txt")
+	testutil.ExpectPathError(t, err, "open", "/shared.txt", tinyfs.ErrLocked)
+	if !errors.Is(err, FileResultLocked) {
+		t.Errorf("expected %v, was actually %v", FileResultLocked, err)
+	}
+	err = fatfs.Remove("/shared.txt")
+	testutil.ExpectPathError(t, err, "remove", "/shared.txt", tinyfs.ErrLocked)
+	err = fatfs.Rename("/shared.txt", "/renamed.txt")
+	if !errors.Is(err, tinyfs.ErrLocked) {
+		t.Errorf("expected %v, was actually %v", tinyfs.ErrLocked, err)
+	}
+	check(t, w.Close())
+
+	// files open for reading can be opened any number of times for reading
+	r1, err := fatfs.Open("/shared.txt")
+	check(t, err)
+	r2, err := fatfs.Open("/shared.txt")
+	check(t, err)
+	_, err = fatfs.OpenFile("/shared.txt", os.O_WRONLY)
+	testutil.ExpectPathError(t, err, "open", "/shared.txt", tinyfs.ErrLocked)
+	check(t, r1.Close())
+	check(t, r2.Close())
+
+	check(t, fatfs.Remove("/shared.txt"))
+	if _, err := fatfs.Stat("/shared.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected %v, was actually %v", fs.ErrNotExist, err)
+	}
+}
@@ -1,9 +1,23 @@
 package fatfs
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"tinygo.org/x/tinyfs"
+	"tinygo.org/x/tinyfs/internal/gopointer"
+	"tinygo.org/x/tinyfs/internal/testutil"
+	"tinygo.org/x/tinyfs/tinyfstest"
 )
 
 const (
@@ -12,6 +26,10 @@ const (
 	testBlockCount = 4096
 )
 
+var defaultConfig = &Config{
+	SectorSize: SectorSize,
+}
+
 func TestType_String(t *testing.T) {
 	expectString(t, "fatfs: (1) A hard error occurred in the low level disk I/O layer", FileResultErr.Error())
 }
@@ -23,7 +41,7 @@ func TestFormat(t *testing.T) {
 		// file, err := os.Open("trinket_filesystem.img")
 		// check(t, err)
 		// dev := NewFileDevice(file, 512, 29)
-		fs, _, umount := createTestFS(t)
+		fs, _, umount := createTestFS(t, defaultConfig)
 		defer umount()
 		n, err := fs.Free()
 		check(t, err)
@@ -31,12 +49,246 @@ func TestFormat(t *testing.T) {
 	})
 }
 
-func createTestFS(t *testing.T) (*FATFS, tinyfs.BlockDevice, func()) {
+func TestFormatWithOptions(t *testing.T) {
+	// bootSector reads the BPB fields of a volume formatted without a
+	// partition table.
+	bootSector := func(t *testing.T, dev tinyfs.BlockDevice) (numFATs, rootEntries, dataStart int) {
+		t.Helper()
+		buf := make([]byte, SectorSize)
+		_, err := dev.ReadAt(buf, 0)
+		check(t, err)
+		u16 := func(off int) int { return int(buf[off]) | int(buf[off+1])<<8 }
+		u32 := func(off int) int { return u16(off) | u16(off+2)<<16 }
+		fatSize := u16(22)
+		if fatSize == 0 {
+			fatSize = u32(36)
+		}
+		numFATs, rootEntries = int(buf[16]), u16(17)
+		dataStart = (u16(14)+numFATs*fatSize)*SectorSize + rootEntries*32
+		return numFATs, rootEntries, dataStart
+	}
+
+	for _, tc := range []struct {
+		name string
+		size int64
+		opts FormatOptions
+	}{
+		{"FAT12", 1 << 20, FormatOptions{Type: TypeFAT12}},
+		{"FAT16", 4 << 20, FormatOptions{Type: TypeFAT16}},
+		{"FAT32", 64 << 20, FormatOptions{Type: TypeFAT32}},
+		{"EXFAT", 8 << 20, FormatOptions{Type: TypeEXFAT}},
+		{"Layout", 8 << 20, FormatOptions{
+			Type:             TypeFAT16,
+			AllocationUnit:   1024,
+			NumFATs:          2,
+			RootEntries:      128,
+			Alignment:        64 << 10,
+			NoPartitionTable: true,
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.opts.Type == TypeEXFAT && !ExFAT {
+				t.Skip("exFAT is disabled by FF_FS_EXFAT")
+			}
+			dev := newSparseDevice(t, tc.size)
+			fatfs := New(dev).Configure(defaultConfig)
+			check(t, fatfs.FormatWithOptions(&tc.opts))
+			if typ := fatfs.Type(); typ != 0 {
+				t.Errorf("expected no type before mounting, was actually %v", typ)
+			}
+			check(t, fatfs.Mount())
+			defer func() {
+				check(t, fatfs.Close())
+			}()
+			if typ := fatfs.Type(); typ != tc.opts.Type {
+				t.Fatalf("expected %v, was actually %v", tc.opts.Type, typ)
+			}
+			if tc.opts.AllocationUnit != 0 {
+				stat, err := fatfs.StatFS()
+				check(t, err)
+				if stat.BlockSize != int64(tc.opts.AllocationUnit) {
+					t.Errorf("expected block size %d, was actually %d", tc.opts.AllocationUnit, stat.BlockSize)
+				}
+			}
+			if tc.opts.NoPartitionTable {
+				numFATs, rootEntries, dataStart := bootSector(t, dev)
+				if numFATs != tc.opts.NumFATs || rootEntries != tc.opts.RootEntries {
+					t.Errorf("expected %d FATs and %d root entries, was actually %d and %d",
+						tc.opts.NumFATs, tc.opts.RootEntries, numFATs, rootEntries)
+				}
+				if dataStart%tc.opts.Alignment != 0 {
+					t.Errorf("expected the data area to be aligned to %d, starts at %d", tc.opts.Alignment, dataStart)
+				}
+			}
+
+			f, err := fatfs.OpenFile("/hello.txt", os.O_WRONLY|os.O_CREATE)
+			check(t, err)
+			_, err = f.Write([]byte("hello"))
+			check(t, err)
+			check(t, f.Close())
+			info, err := fatfs.Stat("/hello.txt")
+			check(t, err)
+			if info.Size() != 5 {
+				t.Errorf("expected size 5, was actually %d", info.Size())
+			}
+		})
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		dev := newSparseDevice(t, 1<<20)
+		fatfs := New(dev).Configure(defaultConfig)
+		check(t, fatfs.FormatWithOptions(&FormatOptions{NoPartitionTable: true}))
+		numFATs, rootEntries, dataStart := bootSector(t, dev)
+		if numFATs != 1 || rootEntries != 512 {
+			t.Errorf("expected 1 FAT and 512 root entries, was actually %d and %d", numFATs, rootEntries)
+		}
+		if align := int(dev.EraseBlockSize()); dataStart%align != 0 {
+			t.Errorf("expected the data area to be aligned to the erase block size %d, starts at %d", align, dataStart)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		fatfs := New(newSparseDevice(t, 4<<20)).Configure(defaultConfig)
+		for _, opts := range []FormatOptions{
+			{Type: Type(9)},
+			{AllocationUnit: 3000},
+			{AllocationUnit: 256},
+			{NumFATs: 3},
+			{RootEntries: 100},
+			{Alignment: 1000},
+		} {
+			err := fatfs.FormatWithOptions(&opts)
+			testutil.ExpectPathError(t, err, "format", "/", fs.ErrInvalid)
+		}
+		// too many clusters for FAT12 at this cluster size
+		err := fatfs.FormatWithOptions(&FormatOptions{Type: TypeFAT12, AllocationUnit: 512})
+		testutil.ExpectPathError(t, err, "format", "/", FileResultMkfsAborted)
+		// too small for FAT32
+		err = fatfs.FormatWithOptions(&FormatOptions{Type: TypeFAT32})
+		testutil.ExpectPathError(t, err, "format", "/", FileResultMkfsAborted)
+	})
+}
+
+func TestSectorSize(t *testing.T) {
+	for ss := MinSectorSize; ss <= MaxSectorSize; ss *= 2 {
+		for name, opts := range map[string]FormatOptions{
+			"Partitioned": {},
+			"SFD":         {NoPartitionTable: true},
+			"EXFAT":       {Type: TypeEXFAT},
+		} {
+			opts := opts
+			t.Run(fmt.Sprintf("%d/%s", ss, name), func(t *testing.T) {
+				if opts.Type == TypeEXFAT && !ExFAT {
+					t.Skip("exFAT is disabled by FF_FS_EXFAT")
+				}
+				dev := newSparseDevice(t, 32<<20)
+				fatfs := New(dev).Configure(&Config{SectorSize: ss})
+				check(t, fatfs.FormatWithOptions(&opts))
+				check(t, fatfs.Mount())
+				stat, err := fatfs.StatFS()
+				check(t, err)
+				if stat.BlockSize < int64(ss) {
+					t.Errorf("expected clusters of at least %d bytes, was actually %d", ss, stat.BlockSize)
+				}
+				f, err := fatfs.OpenFile("/hello.txt", os.O_WRONLY|os.O_CREATE)
+				check(t, err)
+				_, err = f.Write([]byte(strings.Repeat("hello", ss)))
+				check(t, err)
+				check(t, f.Close())
+				check(t, fatfs.Close())
+
+				// the sector size is detected from the boot sector
+				fatfs = New(dev).Configure(&Config{})
+				check(t, fatfs.Mount())
+				defer func() {
+					check(t, fatfs.Close())
+				}()
+				if fatfs.ssize != ss {
+					t.Fatalf("expected sector size %d, was actually %d", ss, fatfs.ssize)
+				}
+				f, err = fatfs.Open("/hello.txt")
+				check(t, err)
+				defer f.Close()
+				expectContents(t, f.(*File), strings.Repeat("hello", ss))
+			})
+		}
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		for _, ss := range []int{256, 1000, MaxSectorSize * 2} {
+			fatfs := New(newSparseDevice(t, 1<<20)).Configure(&Config{SectorSize: ss})
+			testutil.ExpectPathError(t, fatfs.Format(), "format", "/", fs.ErrInvalid)
+			testutil.ExpectPathError(t, fatfs.Mount(), "mount", "/", fs.ErrInvalid)
+		}
+		// an explicit size is kept when no volume is found
+		fatfs := New(newSparseDevice(t, 1<<20)).Configure(&Config{SectorSize: MaxSectorSize})
+		testutil.ExpectPathError(t, fatfs.Mount(), "mount", "/", FileResultNoFilesystem)
+		if fatfs.ssize != MaxSectorSize {
+			t.Errorf("expected sector size %d, was actually %d", MaxSectorSize, fatfs.ssize)
+		}
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		if MaxSectorSize >= maxFATSectorSize {
+			t.Skip("every sector size is supported, build with the fatfs_512 tag")
+		}
+		// a boot sector recording sectors larger than the buffers of this build
+		boot := make([]byte, 512)
+		boot[0], boot[510], boot[511] = 0xEB, 0x55, 0xAA
+		binary.LittleEndian.PutUint16(boot[11:], maxFATSectorSize)
+		dev := newTestDevice()
+		_, err := dev.WriteAt(boot, 0)
+		check(t, err)
+		err = New(dev).Configure(defaultConfig).Mount()
+		testutil.ExpectPathError(t, err, "mount", "/", FileResultInvalidParameter)
+		if err == nil || !strings.Contains(err.Error(), "fatfs_512") {
+			t.Errorf("expected the error to name the fatfs_512 tag, was actually %v", err)
+		}
+	})
+
+	t.Run("TooManySectors", func(t *testing.T) {
+		fatfs, dev, unmount := createTestFS(t, defaultConfig)
+		f, err := fatfs.OpenFile("/file.txt", os.O_WRONLY|os.O_CREATE)
+		check(t, err)
+		_, err = f.Write([]byte("data"))
+		check(t, err)
+		check(t, f.Close())
+		unmount()
+
+		// FatFs cannot number the sectors past 2^32, but a volume within the
+		// first 2^32 sectors is still found
+		fatfs = New(hugeDevice{dev}).Configure(defaultConfig)
+		check(t, fatfs.Mount())
+		defer fatfs.Close()
+		f, err = fatfs.Open("/file.txt")
+		check(t, err)
+		defer f.Close()
+		expectContents(t, f.(*File), "data")
+	})
+}
+
+// hugeDevice reports one sector more than FatFs can number, while storing
+// only the sectors of the device it wraps.
+type hugeDevice struct {
+	tinyfs.BlockDevice
+}
+
+func (hugeDevice) Size() int64 {
+	return (math.MaxUint32 + 1) * SectorSize
+}
+
+// newTestDevice returns a memory device that enforces the rules of NOR
+// flash, behind the cache that lets FAT overwrite sectors in place.
+func newTestDevice() tinyfs.BlockDevice {
+	dev := tinyfs.NewMemoryDevice(testPageSize, testBlockSize, testBlockCount).SetStrict(tinyfs.StrictNOR)
+	return tinyfs.NewEraseBlockCache(dev)
+}
+
+func createTestFS(t *testing.T, config *Config) (*FATFS, tinyfs.BlockDevice, func()) {
 	// create/format/mount the filesystem
-	dev := tinyfs.NewMemoryDevice(testPageSize, testBlockSize, testBlockCount)
-	fs := New(dev)
+	dev := newTestDevice()
+	fs := New(dev).Configure(config)
 	println("formatting")
-	//check(t, fs.Configure())
 	if err := fs.Format(); err != nil {
 		t.Fatal(err)
 	}
@@ -50,9 +302,9 @@ func createTestFS(t *testing.T) (*FATFS, tinyfs.BlockDevice, func()) {
 		t.Error("Could not mount", err)
 	}
 	return fs, dev, func() {
-		//if err := fs.Unmount(); err != nil {
-		//	t.Error("Could not ummount", err)
-		//}
+		if err := fs.Close(); err != nil {
+			t.Error("Could not unmount", err)
+		}
 	}
 }
 
@@ -62,14 +314,14 @@ func TestDirectories(t *testing.T) {
 		largeSize = 128
 	)
 	t.Run("RootDirectory", func(t *testing.T) {
-		fs, _, unmount := createTestFS(t)
+		fs, _, unmount := createTestFS(t, defaultConfig)
 		defer unmount()
 		f, err := fs.Open("/")
 		check(t, err)
 		check(t, f.Close())
 	})
 	t.Run("DirectoryCreation", func(t *testing.T) {
-		fs, _, unmount := createTestFS(t)
+		fs, _, unmount := createTestFS(t, defaultConfig)
 		defer unmount()
 		check(t, fs.Mkdir("potato", 0777))
 		info, err := fs.Stat("potato")
@@ -78,6 +330,769 @@ func TestDirectories(t *testing.T) {
 	})
 }
 
+func TestReaddir(t *testing.T) {
+	fatfs, _, unmount := createTestFS(t, defaultConfig)
+	defer unmount()
+
+	// FatFs rejects directory reads on a file as an invalid object
+	f, err := fatfs.OpenFile("/file.txt", os.O_WRONLY|os.O_CREATE)
+	check(t, err)
+	defer f.Close()
+	if _, err := f.Readdir(1); !errors.Is(err, FileResultInvalidObject) {
+		t.Errorf("expected %v, was actually %v", FileResultInvalidObject, err)
+	}
+	if err := f.(*File).RewindDir(); !errors.Is(err, FileResultInvalidObject) {
+		t.Errorf("expected %v, was actually %v", FileResultInvalidObject, err)
+	}
+}
+
+func TestUnicodeNames(t *testing.T) {
+	fatfs, dev, unmount := createTestFS(t, defaultConfig)
+	defer unmount()
+
+	names := []string{
+		"café.txt",
+		"Ünïcödé Ördner",
+		"日本語のファイル.txt",
+		"Ελληνικά",
+		"party 🎉.txt",
+	}
+	if long := strings.Repeat("ø", 200) + ".log"; len(long) < lfnBufSize {
+		names = append(names, long)
+	}
+	check(t, fatfs.Mkdir("/ñ", 0777))
+	for _, name := range names {
+		f, err := fatfs.OpenFile("/ñ/"+name, os.O_RDWR|os.O_CREATE|os.O_EXCL)
+		check(t, err)
+		_, err = f.Write([]byte(name))
+		check(t, err)
+		check(t, f.Close())
+	}
+
+	t.Run("Stat", func(t *testing.T) {
+		for _, name := range names {
+			info, err := fatfs.Stat("/ñ/" + name)
+			check(t, err)
+			expectString(t, name, info.Name())
+			if info.Size() != int64(len(name)) {
+				t.Errorf("%s: expected size %d, was actually %d", name, len(name), info.Size())
+			}
+		}
+		// names are matched without regard to case, beyond ASCII too
+		info, err := fatfs.Stat("/Ñ/CAFÉ.TXT")
+		check(t, err)
+		if info.Size() != int64(len("café.txt")) {
+			t.Errorf("expected size %d, was actually %d", len("café.txt"), info.Size())
+		}
+	})
+
+	t.Run("Readdir", func(t *testing.T) {
+		dir, err := fatfs.Open("/ñ")
+		check(t, err)
+		defer dir.Close()
+		infos, err := dir.Readdir(0)
+		check(t, err)
+		var actual []string
+		for _, info := range infos {
+			actual = append(actual, info.Name())
+		}
+		expected := append([]string(nil), names...)
+		sort.Strings(expected)
+		testutil.ExpectNames(t, expected, actual)
+	})
+
+	t.Run("OnDisk", func(t *testing.T) {
+		// long names are stored in UTF-16, as other systems expect
+		image := make([]byte, dev.Size())
+		_, err := dev.ReadAt(image, 0)
+		check(t, err)
+		if !bytes.Contains(image, []byte{'c', 0, 'a', 0, 'f', 0, 0xe9, 0}) {
+			t.Error("expected the UTF-16 name of café.txt on the device")
+		}
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		for _, name := range names {
+			renamed := "/ñ/→ " + name
+			check(t, fatfs.Rename("/ñ/"+name, renamed))
+			f, err := fatfs.Open(renamed)
+			check(t, err)
+			expectContents(t, f.(*File), name)
+			check(t, f.Close())
+		}
+	})
+
+	t.Run("InvalidUTF8", func(t *testing.T) {
+		_, err := fatfs.OpenFile("/bad\xff.txt", os.O_RDWR|os.O_CREATE)
+		testutil.ExpectPathError(t, err, "open", "/bad\xff.txt", fs.ErrInvalid)
+	})
+}
+
+func TestExFAT(t *testing.T) {
+	if !ExFAT {
+		t.Skip("exFAT is disabled by FF_FS_EXFAT")
+	}
+	if testing.Short() {
+		t.Skip("writes a file larger than 4 GiB")
+	}
+	// exFAT is chosen for volumes of 32 GiB and up, like SDXC cards
+	dev := newSparseDevice(t, 64<<30)
+	fatfs := New(dev).Configure(defaultConfig)
+	check(t, fatfs.Format())
+	check(t, fatfs.Mount())
+	defer func() {
+		check(t, fatfs.Close())
+	}()
+	if typ := fatfs.Type(); typ != TypeEXFAT {
+		t.Fatalf("expected %v, was actually %v", TypeEXFAT, typ)
+	}
+
+	const size = 4<<30 + 4096
+	tail := []byte("past the 4 GiB limit of FAT32")
+	f, err := fatfs.OpenFile("/big.bin", os.O_RDWR|os.O_CREATE)
+	check(t, err)
+	_, err = f.WriteAt(tail, size-int64(len(tail)))
+	check(t, err)
+	if n, err := f.(*File).Size(); err != nil || n != size {
+		t.Fatalf("expected size %d, was actually %d (%v)", int64(size), n, err)
+	}
+	pos, err := f.Seek(-int64(len(tail)), io.SeekEnd)
+	check(t, err)
+	if pos != size-int64(len(tail)) {
+		t.Fatalf("expected position %d, was actually %d", size-int64(len(tail)), pos)
+	}
+	buf := make([]byte, len(tail))
+	_, err = io.ReadFull(f, buf)
+	check(t, err)
+	expectString(t, string(tail), string(buf))
+	check(t, f.Close())
+
+	info, err := fatfs.Stat("/big.bin")
+	check(t, err)
+	if info.Size() != size {
+		t.Errorf("expected Stat size %d, was actually %d", int64(size), info.Size())
+	}
+	root, err := fatfs.Open("/")
+	check(t, err)
+	infos, err := root.Readdir(0)
+	check(t, err)
+	check(t, root.Close())
+	if len(infos) != 1 || infos[0].Size() != size {
+		t.Errorf("expected Readdir to report %d bytes, was actually %+v", int64(size), infos)
+	}
+
+	// the gap reads as zeros
+	f, err = fatfs.Open("/big.bin")
+	check(t, err)
+	defer f.Close()
+	_, err = f.ReadAt(buf, 4<<30)
+	check(t, err)
+	if !bytes.Equal(buf, make([]byte, len(buf))) {
+		t.Errorf("expected zeros, was actually %q", buf)
+	}
+}
+
+// sparseDevice is a BlockDevice backed by a sparse file on the host. Writes of
+// zeros to regions that were never written are skipped, as those read back as
+// zeros anyway, so that tests can create huge files without using up the
+// disk.
+type sparseDevice struct {
+	file    *os.File
+	size    int64
+	written map[int64]struct{}
+}
+
+// sparseRegionSize is the granularity at which sparseDevice tracks writes
+const sparseRegionSize = 1 << 20
+
+var zeroRegion = make([]byte, sparseRegionSize)
+
+func newSparseDevice(t *testing.T, size int64) *sparseDevice {
+	file, err := os.CreateTemp(t.TempDir(), "sparse-*.img")
+	check(t, err)
+	t.Cleanup(func() { file.Close() })
+	check(t, file.Truncate(size))
+	return &sparseDevice{file: file, size: size, written: make(map[int64]struct{})}
+}
+
+func (dev *sparseDevice) ReadAt(buf []byte, off int64) (int, error) {
+	return dev.file.ReadAt(buf, off)
+}
+
+func (dev *sparseDevice) WriteAt(buf []byte, off int64) (int, error) {
+	for n := 0; n < len(buf); {
+		region := (off + int64(n)) / sparseRegionSize
+		end := int((region+1)*sparseRegionSize - off)
+		if end > len(buf) {
+			end = len(buf)
+		}
+		chunk := buf[n:end]
+		if _, ok := dev.written[region]; ok || !bytes.Equal(chunk, zeroRegion[:len(chunk)]) {
+			if _, err := dev.file.WriteAt(chunk, off+int64(n)); err != nil {
+				return n, err
+			}
+			dev.written[region] = struct{}{}
+		}
+		n = end
+	}
+	return len(buf), nil
+}
+
+func (dev *sparseDevice) Size() int64 {
+	return dev.size
+}
+
+func (dev *sparseDevice) WriteBlockSize() int64 {
+	return SectorSize
+}
+
+func (dev *sparseDevice) EraseBlockSize() int64 {
+	return 4096
+}
+
+func (dev *sparseDevice) EraseBlocks(start, len int64) error {
+	return nil
+}
+
+func TestRenameReplace(t *testing.T) {
+	writeFile := func(fatfs *FATFS, name, contents string) error {
+		f, err := fatfs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write([]byte(contents))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+	// names returns the contents of the files in the root directory by name
+	names := func(fatfs *FATFS) map[string]string {
+		t.Helper()
+		dir, err := fatfs.Open("/")
+		check(t, err)
+		defer dir.Close()
+		infos, err := dir.Readdir(0)
+		check(t, err)
+		files := make(map[string]string)
+		for _, info := range infos {
+			f, err := fatfs.Open("/" + info.Name())
+			check(t, err)
+			data, err := io.ReadAll(f)
+			check(t, err)
+			check(t, f.Close())
+			files[info.Name()] = string(data)
+		}
+		return files
+	}
+
+	t.Run("Replace", func(t *testing.T) {
+		fatfs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, writeFile(fatfs, "/new.txt", "new"))
+		check(t, writeFile(fatfs, "/old.txt", "old"))
+		check(t, fatfs.Rename("/new.txt", "/old.txt"))
+		if files := names(fatfs); len(files) != 1 || files["old.txt"] != "new" {
+			t.Fatalf("expected only old.txt with the new contents, was actually %q", files)
+		}
+	})
+
+	t.Run("PowerCut", func(t *testing.T) {
+		// neither file is lost, wherever the power is cut
+		for n := 1; ; n++ {
+			dev := tinyfs.NewFaultDevice(newTestDevice())
+			fatfs := New(dev).Configure(defaultConfig)
+			check(t, fatfs.Format())
+			check(t, fatfs.Mount())
+			check(t, writeFile(fatfs, "/new.txt", "new"))
+			check(t, writeFile(fatfs, "/old.txt", "old"))
+
+			dev.CutPower(n, 0)
+			err := fatfs.Rename("/new.txt", "/old.txt")
+			cut := dev.PowerLost()
+			dev.PowerOn()
+			fatfs.Close()
+			if !cut {
+				check(t, err)
+			}
+
+			fatfs = New(dev).Configure(defaultConfig)
+			check(t, fatfs.Mount())
+			files := names(fatfs)
+			check(t, fatfs.Close())
+			var found []string
+			for _, contents := range files {
+				found = append(found, contents)
+			}
+			sort.Strings(found)
+			done := files["old.txt"] == "new" && files["new.txt"] == ""
+			if !done && strings.Join(found, ",") != "new,old" {
+				t.Fatalf("power cut %d: expected both files, was actually %q", n, files)
+			}
+			if !cut {
+				if len(files) != 1 || !done {
+					t.Fatalf("expected only old.txt with the new contents, was actually %q", files)
+				}
+				break
+			}
+		}
+	})
+}
+
+func TestStatFS(t *testing.T) {
+	fs, _, unmount := createTestFS(t, defaultConfig)
+	defer unmount()
+
+	before, err := fs.StatFS()
+	check(t, err)
+	if before.BlockSize != fs.clusterSize() {
+		t.Fatalf("expected the cluster size %d as block size, was actually %d", fs.clusterSize(), before.BlockSize)
+	}
+	if before.TotalBytes != before.FreeBytes+before.UsedBytes {
+		t.Fatalf("expected total to be free plus used bytes, was actually %+v", before)
+	}
+	free, err := fs.Free()
+	check(t, err)
+	if free != before.FreeBytes {
+		t.Fatalf("expected Free to return %d, was actually %d", before.FreeBytes, free)
+	}
+
+	// a file uses whole clusters
+	size := 2*before.BlockSize + 1
+	f, err := fs.OpenFile("/big.bin", os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	check(t, err)
+	_, err = f.Write(make([]byte, size))
+	check(t, err)
+	check(t, f.Close())
+
+	after, err := fs.StatFS()
+	check(t, err)
+	if used := before.FreeBytes - after.FreeBytes; used != 3*before.BlockSize {
+		t.Fatalf("expected %d bytes to be used by the file, was actually %d", 3*before.BlockSize, used)
+	}
+	if after.UsedBytes-before.UsedBytes != 3*before.BlockSize {
+		t.Fatalf("expected used bytes to grow by %d, was actually %d", 3*before.BlockSize, after.UsedBytes-before.UsedBytes)
+	}
+
+	t.Run("MaxDirEntries", func(t *testing.T) {
+		if typ := fs.Type(); typ != TypeFAT12 && typ != TypeFAT16 {
+			t.Skipf("no fixed root directory on %v", typ)
+		}
+		if after.MaxDirEntries != int64(fs.fs.n_rootdir) {
+			t.Fatalf("expected the root directory size %d, was actually %d", fs.fs.n_rootdir, after.MaxDirEntries)
+		}
+		// short names take up one entry each, and big.bin is there already
+		for i := int64(1); i < after.MaxDirEntries; i++ {
+			f, err := fs.OpenFile(fmt.Sprintf("/F%d.TXT", i), os.O_WRONLY|os.O_CREATE)
+			check(t, err)
+			check(t, f.Close())
+		}
+		_, err := fs.OpenFile("/FULL.TXT", os.O_WRONLY|os.O_CREATE)
+		if !errors.Is(err, FileResultDenied) {
+			t.Fatalf("expected a full root directory, was actually %v", err)
+		}
+	})
+}
+
+func TestSeek(t *testing.T) {
+	fatfs, _, unmount := createTestFS(t, defaultConfig)
+	defer unmount()
+
+	tf, err := fatfs.OpenFile("seek.txt", os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	check(t, err)
+	f := tf.(*File)
+	defer f.Close()
+	_, err = f.Write([]byte("0123456789"))
+	check(t, err)
+
+	for _, tc := range []struct {
+		name   string
+		offset int64
+		whence int
+		pos    int64
+		next   string
+	}{
+		{"SeekStart", 2, io.SeekStart, 2, "23"},
+		{"SeekCurrent", 1, io.SeekCurrent, 5, "56"},
+		{"SeekCurrentBackwards", -4, io.SeekCurrent, 3, "34"},
+		{"SeekEnd", -3, io.SeekEnd, 7, "78"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pos, err := f.Seek(tc.offset, tc.whence)
+			check(t, err)
+			if pos != tc.pos {
+				t.Fatalf("expected position %d, was actually %d", tc.pos, pos)
+			}
+			if tell, err := f.Tell(); err != nil || tell != tc.pos {
+				t.Fatalf("expected Tell to return %d, was actually %d (%v)", tc.pos, tell, err)
+			}
+			buf := make([]byte, len(tc.next))
+			_, err = io.ReadFull(f, buf)
+			check(t, err)
+			expectString(t, tc.next, string(buf))
+		})
+	}
+
+	t.Run("InvalidSeek", func(t *testing.T) {
+		if _, err := f.Seek(-1, io.SeekStart); !errors.Is(err, fs.ErrInvalid) {
+			t.Fatalf("expected fs.ErrInvalid, was actually %v", err)
+		}
+		if _, err := f.Seek(0, 42); !errors.Is(err, fs.ErrInvalid) {
+			t.Fatalf("expected fs.ErrInvalid, was actually %v", err)
+		}
+	})
+
+	t.Run("Rewind", func(t *testing.T) {
+		check(t, f.Rewind())
+		buf := make([]byte, 3)
+		_, err := io.ReadFull(f, buf)
+		check(t, err)
+		expectString(t, "012", string(buf))
+	})
+
+	t.Run("SeekPastEnd", func(t *testing.T) {
+		pos, err := f.Seek(4, io.SeekEnd)
+		check(t, err)
+		if pos != 14 {
+			t.Fatalf("expected position 14, was actually %d", pos)
+		}
+		_, err = f.Write([]byte("ab"))
+		check(t, err)
+		expectContents(t, f, "0123456789\x00\x00\x00\x00ab")
+	})
+
+	t.Run("Truncate", func(t *testing.T) {
+		_, err := f.Seek(12, io.SeekStart)
+		check(t, err)
+		check(t, f.Truncate(4))
+		if pos, _ := f.Tell(); pos != 4 {
+			t.Fatalf("expected position 4 after truncate, was actually %d", pos)
+		}
+		expectContents(t, f, "0123")
+		check(t, f.Truncate(6))
+		expectContents(t, f, "0123\x00\x00")
+	})
+
+	t.Run("TruncateReadOnly", func(t *testing.T) {
+		check(t, f.Close())
+		tf, err := fatfs.Open("seek.txt")
+		check(t, err)
+		f = tf.(*File)
+		if err := f.Truncate(0); !errors.Is(err, fs.ErrPermission) {
+			t.Fatalf("expected fs.ErrPermission, was actually %v", err)
+		}
+		if pos, err := f.Seek(100, io.SeekStart); err != nil || pos != 100 {
+			t.Fatalf("expected read-only seek to 100, was actually %d (%v)", pos, err)
+		}
+		if pos, err := f.Seek(1, io.SeekCurrent); err != nil || pos != 101 {
+			t.Fatalf("expected read-only seek to 101, was actually %d (%v)", pos, err)
+		}
+		if n, err := f.Read(make([]byte, 4)); n != 0 || err != io.EOF {
+			t.Fatalf("expected io.EOF past the end, was actually %d (%v)", n, err)
+		}
+		if size, _ := f.Size(); size != 6 {
+			t.Fatalf("expected read-only seek to keep size 6, was actually %d", size)
+		}
+		expectContents(t, f, "0123\x00\x00")
+	})
+}
+
+func expectContents(t *testing.T, f *File, expected string) {
+	t.Helper()
+	check(t, f.Rewind())
+	data, err := io.ReadAll(f)
+	check(t, err)
+	if !bytes.Equal([]byte(expected), data) {
+		t.Fatalf("expected contents %q, was actually %q", expected, data)
+	}
+}
+
+func TestModTime(t *testing.T) {
+	now := time.Date(2023, time.July, 4, 12, 30, 10, 0, time.Local)
+	fatfs, _, unmount := createTestFS(t, &Config{
+		SectorSize: SectorSize,
+		Clock:      func() time.Time { return now },
+	})
+	defer unmount()
+
+	f, err := fatfs.OpenFile("timed.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	check(t, err)
+	_, err = f.Write([]byte("tick"))
+	check(t, err)
+	now = now.Add(time.Hour)
+	check(t, f.Close())
+	check(t, fatfs.Mkdir("timed", 0777))
+
+	// files are stamped with the clock when they are closed
+	for _, name := range []string{"timed.txt", "timed"} {
+		info, err := fatfs.Stat(name)
+		check(t, err)
+		if !info.ModTime().Equal(now) {
+			t.Errorf("%s: expected modification time %v, was actually %v", name, now, info.ModTime())
+		}
+	}
+}
+
+func TestClock(t *testing.T) {
+	// the FF_NORTC_* date configured in ffconf.h
+	noRTC := time.Date(2018, time.January, 1, 0, 0, 0, 0, time.Local)
+	for _, tc := range []struct {
+		name     string
+		clock    func() time.Time
+		expected time.Time
+	}{
+		{"NoClock", nil, noRTC},
+		{"OutOfRange", func() time.Time { return time.Unix(0, 0) }, noRTC},
+		{"Clock", func() time.Time { return time.Date(2030, time.January, 2, 3, 4, 6, 0, time.Local) },
+			time.Date(2030, time.January, 2, 3, 4, 6, 0, time.Local)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fatfs, _, unmount := createTestFS(t, &Config{SectorSize: SectorSize, Clock: tc.clock})
+			defer unmount()
+			check(t, fatfs.Mkdir("dir", 0777))
+			info, err := fatfs.Stat("dir")
+			check(t, err)
+			if !info.ModTime().Equal(tc.expected) {
+				t.Fatalf("expected modification time %v, was actually %v", tc.expected, info.ModTime())
+			}
+		})
+	}
+
+	t.Run("PerVolume", func(t *testing.T) {
+		t1 := time.Date(2001, time.February, 3, 4, 5, 6, 0, time.Local)
+		t2 := time.Date(2012, time.November, 10, 9, 8, 6, 0, time.Local)
+		fs1, _, unmount1 := createTestFS(t, &Config{SectorSize: SectorSize, Clock: func() time.Time { return t1 }})
+		defer unmount1()
+		fs2, _, unmount2 := createTestFS(t, &Config{SectorSize: SectorSize, Clock: func() time.Time { return t2 }})
+		defer unmount2()
+		check(t, fs1.Mkdir("dir", 0777))
+		check(t, fs2.Mkdir("dir", 0777))
+		for _, v := range []struct {
+			fs       *FATFS
+			expected time.Time
+		}{{fs1, t1}, {fs2, t2}} {
+			info, err := v.fs.Stat("dir")
+			check(t, err)
+			if !info.ModTime().Equal(v.expected) {
+				t.Errorf("expected modification time %v, was actually %v", v.expected, info.ModTime())
+			}
+		}
+	})
+}
+
+func TestLifecycle(t *testing.T) {
+	dev := newTestDevice()
+	saved := gopointer.Len()
+
+	t.Run("Reconfigure", func(t *testing.T) {
+		fs := New(dev)
+		for i := 0; i < 10; i++ {
+			fs.Configure(defaultConfig)
+			check(t, fs.Format())
+			check(t, fs.Mount())
+			if n := gopointer.Len() - saved; n != 1 {
+				t.Fatalf("expected 1 outstanding gopointer, was actually %d", n)
+			}
+		}
+		check(t, fs.Close())
+		if n := gopointer.Len() - saved; n != 0 {
+			t.Fatalf("expected no outstanding gopointers, was actually %d", n)
+		}
+		err := fs.Mount()
+		testutil.ExpectPathError(t, err, "mount", "/", FileResultNotEnabled)
+	})
+
+	t.Run("OpenHandles", func(t *testing.T) {
+		fs := New(dev).Configure(defaultConfig)
+		check(t, fs.Format())
+		check(t, fs.Mount())
+		check(t, fs.Mkdir("/dir", 0777))
+		dir, err := fs.Open("/dir")
+		check(t, err)
+		f, err := fs.OpenFile("/pending.txt", os.O_WRONLY|os.O_CREATE)
+		check(t, err)
+		_, err = f.Write([]byte("pending"))
+		check(t, err)
+
+		// closing the filesystem closes the files, which writes them out
+		check(t, fs.Close())
+		if n := gopointer.Len() - saved; n != 0 {
+			t.Fatalf("expected no outstanding gopointers, was actually %d", n)
+		}
+		check(t, f.Close())
+		check(t, dir.Close())
+
+		fs = New(dev).Configure(defaultConfig)
+		defer fs.Close()
+		check(t, fs.Mount())
+		f, err = fs.Open("/pending.txt")
+		check(t, err)
+		defer f.Close()
+		expectContents(t, f.(*File), "pending")
+	})
+}
+
+func TestNotMounted(t *testing.T) {
+	fatfs, _, unmount := createTestFS(t, defaultConfig)
+	defer unmount()
+
+	for _, tc := range []struct {
+		name  string
+		leave func() error
+	}{
+		{"Unmount", fatfs.Unmount},
+		{"Close", func() error {
+			check(t, fatfs.Mount())
+			return fatfs.Close()
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			check(t, tc.leave())
+			// Close has freed the work area, so Free may not reach FatFs
+			testutil.ExpectPathError(t, testutil.Second(fatfs.Free()), "free", "/", tinyfs.ErrNotMounted)
+		})
+	}
+}
+
+func TestLockTimeout(t *testing.T) {
+	fatfs, _, unmount := createTestFS(t, &Config{
+		SectorSize:  SectorSize,
+		ThreadSafe:  true,
+		LockTimeout: 10 * time.Millisecond,
+	})
+	defer unmount()
+
+	// another operation holds on to the volume
+	if !fatfs.acquire() {
+		t.Fatal("could not acquire an idle volume")
+	}
+	_, err := fatfs.Stat("/missing")
+	fatfs.release()
+	testutil.ExpectPathError(t, err, "stat", "/missing", os.ErrDeadlineExceeded)
+	if !errors.Is(err, FileResultTimeout) {
+		t.Errorf("expected %v, was actually %v", FileResultTimeout, err)
+	}
+
+	check(t, fatfs.Mkdir("/after", 0777))
+}
+
+func TestMaxOpenFiles(t *testing.T) {
+	tinyfstest.TestMaxOpenFiles(t, func() tinyfs.Filesystem {
+		return newFormattedFS(t, &Config{SectorSize: SectorSize, MaxOpenFiles: 2})
+	}, 2)
+	if !errors.Is(FileResultTooManyOpenFiles, tinyfs.ErrTooManyOpenFiles) {
+		t.Errorf("expected %v to be %v", FileResultTooManyOpenFiles, tinyfs.ErrTooManyOpenFiles)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, tc := range []struct {
+		err    FileResult
+		target error
+	}{
+		{FileResultNoFile, fs.ErrNotExist},
+		{FileResultNoPath, fs.ErrNotExist},
+		{FileResultExist, fs.ErrExist},
+		{FileResultDenied, fs.ErrPermission},
+		{FileResultWriteProtected, fs.ErrPermission},
+		{FileResultReadOnly, fs.ErrPermission},
+		{FileResultInvalidName, fs.ErrInvalid},
+		{FileResultInvalidParameter, fs.ErrInvalid},
+		{FileResultInvalidObject, fs.ErrClosed},
+		{FileResultTooManyOpenFiles, tinyfs.ErrTooManyOpenFiles},
+	} {
+		if !errors.Is(tc.err, tc.target) {
+			t.Errorf("expected %v to be %v", tc.err, tc.target)
+		}
+	}
+	if errors.Is(FileResultErr, fs.ErrNotExist) {
+		t.Errorf("expected %v not to be %v", FileResultErr, fs.ErrNotExist)
+	}
+
+	// FatFs reports a full volume with a short write instead of an error
+	t.Run("NoSpace", func(t *testing.T) {
+		fatfs := newFormattedFS(t, defaultConfig)
+		check(t, fatfs.Mount())
+		defer fatfs.Unmount()
+		f, err := fatfs.OpenFile("/big", os.O_WRONLY|os.O_CREATE)
+		check(t, err)
+		defer f.Close()
+		chunk := bytes.Repeat([]byte{0xAA}, testBlockSize*testBlockCount/4)
+		for err == nil {
+			_, err = f.Write(chunk)
+		}
+		testutil.ExpectPathError(t, err, "write", "/big", tinyfs.ErrNoSpace)
+	})
+}
+
+func TestBlockDeviceErrors(t *testing.T) {
+	tinyfstest.TestBlockDeviceErrors(t, newTestDevice, func(dev tinyfs.BlockDevice) tinyfs.Filesystem {
+		return New(dev).Configure(defaultConfig)
+	})
+
+	// FatFs reports every failure of the device as a disk error
+	dev := tinyfs.NewFaultDevice(newTestDevice())
+	fatfs := New(dev).Configure(defaultConfig)
+	check(t, fatfs.Format())
+	check(t, fatfs.Mount())
+	defer func() {
+		dev.PowerOn()
+		check(t, fatfs.Close())
+	}()
+	expectDiskErr := func(err error) {
+		t.Helper()
+		if !errors.Is(err, FileResultErr) {
+			t.Errorf("expected %v, was actually %v", FileResultErr, err)
+		}
+	}
+
+	t.Run("Mount", func(t *testing.T) {
+		check(t, fatfs.Unmount())
+		dev.FailRead(1)
+		testutil.ExpectPathError(t, fatfs.Mount(), "mount", "/", FileResultErr)
+		check(t, fatfs.Mount())
+	})
+
+	t.Run("ShortWrite", func(t *testing.T) {
+		f, err := fatfs.OpenFile("/file.txt", os.O_WRONLY|os.O_CREATE)
+		check(t, err)
+		_, err = f.Write([]byte(strings.Repeat("old contents ", 100)))
+		check(t, err)
+		check(t, f.Sync())
+		// a short write stays in the buffer of the file until it is synced
+		dev.CutPower(1, SectorSize/2)
+		_, err = f.Write([]byte("tail"))
+		expectDiskErr(f.Sync())
+		expectDiskErr(f.Close())
+		check(t, err)
+		dev.PowerOn()
+		check(t, fatfs.Mount())
+	})
+
+	t.Run("Format", func(t *testing.T) {
+		dev.FailProgram(1)
+		testutil.ExpectPathError(t, fatfs.Format(), "format", "/", FileResultErr)
+		check(t, fatfs.Format())
+		check(t, fatfs.Mount())
+	})
+}
+
+func TestConformance(t *testing.T) {
+	// FatFs stamps files with the configured clock, which must be the
+	// current time here
+	tinyfstest.TestFilesystem(t, func() tinyfs.Filesystem {
+		return newFormattedFS(t, &Config{SectorSize: SectorSize, Clock: time.Now})
+	})
+}
+
+// newFormattedFS returns a filesystem on a new test device that is formatted
+// but not mounted.
+func newFormattedFS(t *testing.T, config *Config) *FATFS {
+	fatfs := New(newTestDevice()).Configure(config)
+	check(t, fatfs.Format())
+	return fatfs
+}
+
 func expectString(t *testing.T, expected string, actual string) {
 	if expected != actual {
 		t.Fatalf("expected \"%s\", was actually \"%s\"", expected, actual)
@@ -10,10 +10,16 @@
 #include <windows.h>
 #include <tchar.h>
 typedef unsigned __int64 QWORD;
+#define FF_INTDEF 2
 
 
 #else           /* Embedded platform */
 
+/* The C99 fixed-width types keep DWORD at 32 bits on 64-bit hosts too, which
+   the exFAT checksums rely on. FF_INTDEF 2 tells ff.h that QWORD exists. */
+#include <stdint.h>
+#define FF_INTDEF 2
+
 /* These types MUST be 16-bit or 32-bit */
 typedef int             INT;
 typedef unsigned int    UINT;
@@ -22,16 +28,16 @@ typedef unsigned int    UINT;
 typedef unsigned char   BYTE;
 
 /* These types MUST be 16-bit */
-typedef short           SHORT;
-typedef unsigned short  WORD;
-typedef unsigned short  WCHAR;
+typedef int16_t         SHORT;
+typedef uint16_t        WORD;
+typedef uint16_t        WCHAR;
 
 /* These types MUST be 32-bit */
-typedef long            LONG;
-typedef unsigned long   DWORD;
+typedef int32_t         LONG;
+typedef uint32_t        DWORD;
 
-/* This type MUST be 64-bit (Remove this for ANSI C (C89) compatibility) */
-typedef unsigned long long QWORD;
+/* This type MUST be 64-bit */
+typedef uint64_t        QWORD;
 
 #endif
 
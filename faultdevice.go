@@ -0,0 +1,262 @@
+package tinyfs
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+)
+
+// ErrInjectedFault is returned by a FaultDevice for the operations it was
+// told to fail.
+var ErrInjectedFault = errors.New("injected block device fault")
+
+// ErrPowerCut is returned by a FaultDevice for the program that was cut
+// short by a simulated power loss, and for every operation after it until
+// PowerOn is called.
+var ErrPowerCut = errors.New("block device lost power")
+
+// ErrWornOut is returned by a FaultDevice for erases of and programs to
+// blocks that were erased more often than they can endure.
+var ErrWornOut = errors.New("block is worn out")
+
+// FaultDevice is a BlockDevice that injects faults into the operations on
+// another one, to test how filesystems deal with I/O errors, power loss and
+// wear. Without any faults set up, it passes all operations through.
+type FaultDevice struct {
+	dev BlockDevice
+	mu  sync.Mutex
+
+	// failRead, failProgram and failErase count down the operations until
+	// the one that fails, if they are set
+	failRead    int
+	failProgram int
+	failErase   int
+
+	// powerCut counts down the programs until the one that is torn after
+	// tornBytes bytes, and powerLost is set from then on
+	powerCut  int
+	tornBytes int
+	powerLost bool
+
+	// flipRate is the chance for a read to have a random bit flipped
+	flipRate float64
+	rand     *rand.Rand
+
+	// erases counts the erases of each erase block, which fail once they
+	// reach endurance, if it is set
+	erases    map[int64]int
+	endurance int
+}
+
+var _ BlockDevice = (*FaultDevice)(nil)
+var _ Syncer = (*FaultDevice)(nil)
+
+// NewFaultDevice returns a device that injects faults into the operations on
+// dev.
+func NewFaultDevice(dev BlockDevice) *FaultDevice {
+	return &FaultDevice{
+		dev:    dev,
+		erases: make(map[int64]int),
+	}
+}
+
+// FailRead makes the nth read from now on fail with ErrInjectedFault, 1 being
+// the next one. Zero cancels the fault.
+func (d *FaultDevice) FailRead(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failRead = n
+}
+
+// FailProgram makes the nth write from now on fail with ErrInjectedFault
+// without changing the device, 1 being the next one. Zero cancels the fault.
+func (d *FaultDevice) FailProgram(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failProgram = n
+}
+
+// FailErase makes the nth erase from now on fail with ErrInjectedFault
+// without changing the device, 1 being the next one. Zero cancels the fault.
+func (d *FaultDevice) FailErase(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failErase = n
+}
+
+// CutPower simulates losing power during the nth write from now on, 1 being
+// the next one. Only the first torn bytes of that write are programmed,
+// leaving a partially programmed page behind, and it fails with ErrPowerCut
+// like every operation after it until PowerOn is called. Zero cancels the
+// power cut.
+func (d *FaultDevice) CutPower(n int, torn int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.powerCut, d.tornBytes = n, torn
+}
+
+// PowerOn restores the power after a power cut.
+func (d *FaultDevice) PowerOn() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.powerLost = false
+}
+
+// PowerLost reports whether a power cut happened and the power was not
+// restored since.
+func (d *FaultDevice) PowerLost() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.powerLost
+}
+
+// FlipBits makes reads return a random bit flipped with the chance rate,
+// from 0 to 1. The choice of reads and bits is repeatable for a seed. The
+// device itself is not changed.
+func (d *FaultDevice) FlipBits(rate float64, seed int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.flipRate, d.rand = rate, rand.New(rand.NewSource(seed))
+}
+
+// WearOut makes erase blocks wear out once they have been erased endurance
+// times, counting the erases through the device since it was created.
+// Erases of and programs to worn-out blocks fail with ErrWornOut. Zero lets
+// blocks last forever.
+func (d *FaultDevice) WearOut(endurance int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.endurance = endurance
+}
+
+// EraseCount returns the number of times the erase block was erased through
+// the device.
+func (d *FaultDevice) EraseCount(block int64) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.erases[block]
+}
+
+func (d *FaultDevice) ReadAt(buf []byte, off int64) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.powerLost {
+		return 0, ErrPowerCut
+	}
+	if countdown(&d.failRead) {
+		return 0, ErrInjectedFault
+	}
+	n, err := d.dev.ReadAt(buf, off)
+	if n > 0 && d.flipRate > 0 && d.rand.Float64() < d.flipRate {
+		bit := d.rand.Intn(n * 8)
+		buf[bit/8] ^= 1 << (bit % 8)
+	}
+	return n, err
+}
+
+func (d *FaultDevice) WriteAt(buf []byte, off int64) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.powerLost {
+		return 0, ErrPowerCut
+	}
+	if d.worn(off, int64(len(buf))) {
+		return 0, ErrWornOut
+	}
+	if countdown(&d.failProgram) {
+		return 0, ErrInjectedFault
+	}
+	if countdown(&d.powerCut) {
+		d.powerLost = true
+		torn := d.tornBytes
+		if torn > len(buf) {
+			torn = len(buf)
+		}
+		if torn > 0 {
+			// program the whole write, but with the current contents of
+			// the memory after the torn bytes, which leaves it unchanged
+			partial := make([]byte, len(buf))
+			if _, err := d.dev.ReadAt(partial, off); err != nil {
+				return 0, err
+			}
+			copy(partial, buf[:torn])
+			if _, err := d.dev.WriteAt(partial, off); err != nil {
+				return 0, err
+			}
+		}
+		return torn, ErrPowerCut
+	}
+	return d.dev.WriteAt(buf, off)
+}
+
+func (d *FaultDevice) Size() int64 {
+	return d.dev.Size()
+}
+
+func (d *FaultDevice) WriteBlockSize() int64 {
+	return d.dev.WriteBlockSize()
+}
+
+func (d *FaultDevice) EraseBlockSize() int64 {
+	return d.dev.EraseBlockSize()
+}
+
+func (d *FaultDevice) EraseBlocks(start, len int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.powerLost {
+		return ErrPowerCut
+	}
+	size := d.dev.EraseBlockSize()
+	if d.worn(start*size, len*size) {
+		return ErrWornOut
+	}
+	if countdown(&d.failErase) {
+		return ErrInjectedFault
+	}
+	if err := d.dev.EraseBlocks(start, len); err != nil {
+		return err
+	}
+	for block := start; block < start+len; block++ {
+		d.erases[block]++
+	}
+	return nil
+}
+
+// Sync syncs the device if it is a Syncer.
+func (d *FaultDevice) Sync() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.powerLost {
+		return ErrPowerCut
+	}
+	if syncer, ok := d.dev.(Syncer); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
+
+// worn reports whether any of the erase blocks in the given range of bytes
+// is worn out.
+func (d *FaultDevice) worn(off, len int64) bool {
+	if d.endurance <= 0 || len <= 0 {
+		return false
+	}
+	size := d.dev.EraseBlockSize()
+	for block := off / size; block <= (off+len-1)/size; block++ {
+		if d.erases[block] >= d.endurance {
+			return true
+		}
+	}
+	return false
+}
+
+// countdown counts down n, and reports whether it reached zero, which is when
+// the operation it counts is the one that should fail.
+func countdown(n *int) bool {
+	if *n <= 0 {
+		return false
+	}
+	*n--
+	return *n == 0
+}
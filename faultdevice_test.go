@@ -0,0 +1,116 @@
+package tinyfs_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"tinygo.org/x/tinyfs"
+)
+
+func TestFaultDevice(t *testing.T) {
+	expectErr := func(err, target error) {
+		t.Helper()
+		if !errors.Is(err, target) {
+			t.Errorf("expected %v, was actually %v", target, err)
+		}
+	}
+	newDevice := func() (*tinyfs.FaultDevice, *tinyfs.MemBlockDevice) {
+		mem := tinyfs.NewMemoryDevice(16, 64, 4).SetStrict(tinyfs.StrictNOR)
+		return tinyfs.NewFaultDevice(mem), mem
+	}
+	page := bytes.Repeat([]byte{0x5a}, 16)
+	buf := make([]byte, 16)
+
+	t.Run("Fail", func(t *testing.T) {
+		dev, mem := newDevice()
+		dev.FailRead(2)
+		dev.FailProgram(1)
+		dev.FailErase(1)
+		_, err := dev.ReadAt(buf, 0)
+		expectErr(err, nil)
+		_, err = dev.ReadAt(buf, 0)
+		expectErr(err, tinyfs.ErrInjectedFault)
+		_, err = dev.ReadAt(buf, 0)
+		expectErr(err, nil)
+		_, err = dev.WriteAt(page, 0)
+		expectErr(err, tinyfs.ErrInjectedFault)
+		mem.ReadAt(buf, 0)
+		if !bytes.Equal(buf, bytes.Repeat([]byte{0xff}, 16)) {
+			t.Errorf("expected the failed program to leave memory alone, was actually %x", buf)
+		}
+		_, err = dev.WriteAt(page, 0)
+		expectErr(err, nil)
+		expectErr(dev.EraseBlocks(0, 1), tinyfs.ErrInjectedFault)
+		expectErr(dev.EraseBlocks(0, 1), nil)
+	})
+
+	t.Run("CutPower", func(t *testing.T) {
+		dev, mem := newDevice()
+		dev.CutPower(2, 5)
+		_, err := dev.WriteAt(page, 0)
+		expectErr(err, nil)
+		n, err := dev.WriteAt(page, 16)
+		expectErr(err, tinyfs.ErrPowerCut)
+		if n != 5 || !dev.PowerLost() {
+			t.Errorf("expected 5 bytes to be written before the power was lost, was actually %d", n)
+		}
+		_, err = dev.ReadAt(buf, 0)
+		expectErr(err, tinyfs.ErrPowerCut)
+		expectErr(dev.EraseBlocks(0, 1), tinyfs.ErrPowerCut)
+		expectErr(dev.Sync(), tinyfs.ErrPowerCut)
+
+		dev.PowerOn()
+		_, err = dev.ReadAt(buf, 16)
+		expectErr(err, nil)
+		expected := append(page[:5:5], bytes.Repeat([]byte{0xff}, 11)...)
+		if !bytes.Equal(buf, expected) {
+			t.Errorf("expected a torn page %x, was actually %x", expected, buf)
+		}
+		mem.ReadAt(buf, 0)
+		if !bytes.Equal(buf, page) {
+			t.Errorf("expected the first program to be complete, was actually %x", buf)
+		}
+	})
+
+	t.Run("FlipBits", func(t *testing.T) {
+		dev, _ := newDevice()
+		_, err := dev.WriteAt(page, 0)
+		expectErr(err, nil)
+		dev.FlipBits(1, 1)
+		_, err = dev.ReadAt(buf, 0)
+		expectErr(err, nil)
+		flipped := 0
+		for i := range buf {
+			for diff := buf[i] ^ page[i]; diff != 0; diff &= diff - 1 {
+				flipped++
+			}
+		}
+		if flipped != 1 {
+			t.Errorf("expected 1 flipped bit, was actually %d", flipped)
+		}
+		dev.FlipBits(0, 1)
+		_, err = dev.ReadAt(buf, 0)
+		expectErr(err, nil)
+		if !bytes.Equal(buf, page) {
+			t.Errorf("expected no flipped bits, was actually %x", buf)
+		}
+	})
+
+	t.Run("WearOut", func(t *testing.T) {
+		dev, _ := newDevice()
+		dev.WearOut(2)
+		expectErr(dev.EraseBlocks(1, 1), nil)
+		expectErr(dev.EraseBlocks(1, 1), nil)
+		if n := dev.EraseCount(1); n != 2 {
+			t.Errorf("expected 2 erases, was actually %d", n)
+		}
+		expectErr(dev.EraseBlocks(1, 1), tinyfs.ErrWornOut)
+		expectErr(dev.EraseBlocks(0, 2), tinyfs.ErrWornOut)
+		_, err := dev.WriteAt(page, 64)
+		expectErr(err, tinyfs.ErrWornOut)
+		_, err = dev.WriteAt(page, 0)
+		expectErr(err, nil)
+		expectErr(dev.EraseBlocks(0, 1), nil)
+	})
+}
@@ -0,0 +1,187 @@
+package tinyfs_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+
+	"tinygo.org/x/tinyfs"
+)
+
+// flagOutcome records what happened when opening a file with a set of flags,
+// writing "XY" to it if it is writable, and reading it back.
+type flagOutcome struct {
+	openErr  error
+	contents string
+}
+
+func (o flagOutcome) String() string {
+	if o.openErr != nil {
+		return fmt.Sprintf("error(%v)", o.openErr)
+	}
+	return fmt.Sprintf("%q", o.contents)
+}
+
+func TestOpenFlags(t *testing.T) {
+	const missing = "<missing>"
+
+	for _, tc := range []struct {
+		flags    int
+		exists   bool
+		expected flagOutcome
+	}{
+		{os.O_RDONLY, true, flagOutcome{nil, "hello"}},
+		{os.O_RDONLY, false, flagOutcome{fs.ErrNotExist, missing}},
+		{os.O_RDONLY | os.O_CREATE, false, flagOutcome{nil, ""}},
+		{os.O_RDONLY | os.O_TRUNC, true, flagOutcome{fs.ErrInvalid, "hello"}},
+		{os.O_WRONLY, true, flagOutcome{nil, "XYllo"}},
+		{os.O_WRONLY, false, flagOutcome{fs.ErrNotExist, missing}},
+		{os.O_WRONLY | os.O_CREATE, true, flagOutcome{nil, "XYllo"}},
+		{os.O_WRONLY | os.O_CREATE, false, flagOutcome{nil, "XY"}},
+		{os.O_WRONLY | os.O_TRUNC, true, flagOutcome{nil, "XY"}},
+		{os.O_WRONLY | os.O_TRUNC, false, flagOutcome{fs.ErrNotExist, missing}},
+		{os.O_WRONLY | os.O_APPEND, true, flagOutcome{nil, "helloXY"}},
+		{os.O_WRONLY | os.O_APPEND, false, flagOutcome{fs.ErrNotExist, missing}},
+		{os.O_WRONLY | os.O_CREATE | os.O_TRUNC, true, flagOutcome{nil, "XY"}},
+		{os.O_WRONLY | os.O_CREATE | os.O_APPEND, true, flagOutcome{nil, "helloXY"}},
+		{os.O_WRONLY | os.O_CREATE | os.O_APPEND, false, flagOutcome{nil, "XY"}},
+		{os.O_RDWR, true, flagOutcome{nil, "XYllo"}},
+		{os.O_RDWR | os.O_CREATE | os.O_EXCL, true, flagOutcome{fs.ErrExist, "hello"}},
+		{os.O_RDWR | os.O_CREATE | os.O_EXCL, false, flagOutcome{nil, "XY"}},
+		{os.O_RDWR | os.O_CREATE | os.O_TRUNC, true, flagOutcome{nil, "XY"}},
+		{os.O_RDWR | os.O_APPEND, true, flagOutcome{nil, "helloXY"}},
+		{os.O_RDWR | os.O_SYNC, true, flagOutcome{nil, "XYllo"}},
+		{os.O_WRONLY | os.O_RDWR, true, flagOutcome{fs.ErrInvalid, "hello"}},
+	} {
+		for name, newFS := range filesystems() {
+			t.Run(fmt.Sprintf("%s/%s/exists=%t", name, flagString(tc.flags), tc.exists), func(t *testing.T) {
+				actual := openWithFlags(t, mountTestFS(t, newFS), tc.flags, tc.exists)
+				if !sameOutcome(tc.expected, actual) {
+					t.Errorf("expected %v, was actually %v", tc.expected, actual)
+				}
+			})
+		}
+	}
+}
+
+// TestOpenFlagsConsistent checks that every combination of flags has the same
+// outcome on all of the filesystem drivers.
+func TestOpenFlagsConsistent(t *testing.T) {
+	modifiers := []int{os.O_APPEND, os.O_CREATE, os.O_EXCL, os.O_TRUNC}
+	for _, access := range []int{os.O_RDONLY, os.O_WRONLY, os.O_RDWR} {
+		for set := 0; set < 1<<len(modifiers); set++ {
+			flags := access
+			for i, modifier := range modifiers {
+				if set&(1<<i) != 0 {
+					flags |= modifier
+				}
+			}
+			for _, exists := range []bool{true, false} {
+				t.Run(fmt.Sprintf("%s/exists=%t", flagString(flags), exists), func(t *testing.T) {
+					outcomes := map[string]flagOutcome{}
+					for name, newFS := range filesystems() {
+						outcomes[name] = openWithFlags(t, mountTestFS(t, newFS), flags, exists)
+					}
+					if !sameOutcome(outcomes["littlefs"], outcomes["fatfs"]) {
+						t.Errorf("littlefs: %v, fatfs: %v", outcomes["littlefs"], outcomes["fatfs"])
+					}
+				})
+			}
+		}
+	}
+}
+
+func openWithFlags(t *testing.T, filesystem tinyfs.Filesystem, flags int, exists bool) (outcome flagOutcome) {
+	const name = "/flags.txt"
+	if exists {
+		f, err := filesystem.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := filesystem.OpenFile(name, flags)
+	if err != nil {
+		outcome.openErr = err
+	} else {
+		if flags&(os.O_WRONLY|os.O_RDWR) != 0 {
+			if _, err := f.Write([]byte("XY")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err = filesystem.Open(name)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal(err)
+		}
+		outcome.contents = "<missing>"
+		return outcome
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outcome.contents = string(data)
+	return outcome
+}
+
+// sameOutcome compares outcomes, considering errors equal if they are of the
+// same kind.
+func sameOutcome(expected, actual flagOutcome) bool {
+	if expected.contents != actual.contents {
+		return false
+	}
+	if expected.openErr == nil || actual.openErr == nil {
+		return expected.openErr == nil && actual.openErr == nil
+	}
+	for _, kind := range []error{fs.ErrNotExist, fs.ErrExist, fs.ErrInvalid, fs.ErrPermission} {
+		if errors.Is(expected.openErr, kind) || errors.Is(actual.openErr, kind) {
+			return errors.Is(expected.openErr, kind) && errors.Is(actual.openErr, kind)
+		}
+	}
+	return false
+}
+
+func flagString(flags int) string {
+	var s string
+	switch flags & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_RDONLY:
+		s = "O_RDONLY"
+	case os.O_WRONLY:
+		s = "O_WRONLY"
+	case os.O_RDWR:
+		s = "O_RDWR"
+	default:
+		s = "O_WRONLY|O_RDWR"
+	}
+	for _, f := range []struct {
+		flag int
+		name string
+	}{
+		{os.O_APPEND, "O_APPEND"},
+		{os.O_CREATE, "O_CREATE"},
+		{os.O_EXCL, "O_EXCL"},
+		{os.O_SYNC, "O_SYNC"},
+		{os.O_TRUNC, "O_TRUNC"},
+	} {
+		if flags&f.flag != 0 {
+			s += "|" + f.name
+		}
+	}
+	return s
+}
@@ -0,0 +1,84 @@
+// Package flock implements the advisory file locks of the filesystem drivers,
+// which behave like flock(2): any number of shared locks or a single exclusive
+// lock may be held on a file, by open files rather than by goroutines.
+package flock
+
+import "sync"
+
+// Table keeps track of the locks held on the files of one volume, identified
+// by a key that the driver derives from the path of the file. The zero value
+// is an empty table.
+type Table struct {
+	mu    sync.Mutex
+	cond  sync.Cond
+	locks map[string]map[interface{}]bool
+}
+
+// Lock takes a lock on the file identified by key for owner, exclusive or
+// shared. A lock already held by owner is converted; if that has to wait, the
+// old lock is released first, as flock(2) does. If wait is false, Lock does
+// not wait for conflicting locks to be released and reports false instead,
+// leaving any lock held by owner in place.
+func (t *Table) Lock(key string, owner interface{}, exclusive bool, wait bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cond.L == nil {
+		t.cond.L = &t.mu
+	}
+	if !t.available(key, owner, exclusive) {
+		if !wait {
+			return false
+		}
+		t.release(key, owner)
+		for !t.available(key, owner, exclusive) {
+			t.cond.Wait()
+		}
+	}
+	if t.locks == nil {
+		t.locks = make(map[string]map[interface{}]bool)
+	}
+	holders := t.locks[key]
+	if holders == nil {
+		holders = make(map[interface{}]bool)
+		t.locks[key] = holders
+	}
+	if holders[owner] && !exclusive {
+		// downgrading lets waiters for shared locks in
+		t.cond.Broadcast()
+	}
+	holders[owner] = exclusive
+	return true
+}
+
+// Unlock releases the lock held by owner on the file identified by key, if
+// any.
+func (t *Table) Unlock(key string, owner interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.release(key, owner)
+}
+
+// available reports whether owner can take the lock without conflicting with
+// the locks of other owners.
+func (t *Table) available(key string, owner interface{}, exclusive bool) bool {
+	for holder, held := range t.locks[key] {
+		if holder != owner && (exclusive || held) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *Table) release(key string, owner interface{}) {
+	holders := t.locks[key]
+	if _, ok := holders[owner]; !ok {
+		return
+	}
+	delete(holders, owner)
+	if len(holders) == 0 {
+		delete(t.locks, key)
+	}
+	if t.cond.L != nil {
+		t.cond.Broadcast()
+	}
+}
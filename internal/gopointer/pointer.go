@@ -55,3 +55,11 @@ func Unref(ptr unsafe.Pointer) {
 
 	C.free(ptr)
 }
+
+// Len returns the number of saved values that have not been released with
+// Unref, which allows tests to check for leaks.
+func Len() int {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return len(store)
+}
@@ -0,0 +1,43 @@
+// Package testutil holds the assertions shared by the tests of the drivers and
+// by tinyfstest.
+package testutil
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// ExpectPathError checks that err is an *os.PathError for op on path, wrapping
+// an error that matches target.
+func ExpectPathError(t testing.TB, err error, op string, path string, target error) {
+	t.Helper()
+	pathErr, ok := err.(*os.PathError)
+	if !ok {
+		t.Errorf("expected *os.PathError, was actually %T: %v", err, err)
+		return
+	}
+	if pathErr.Op != op || pathErr.Path != path {
+		t.Errorf("expected op %q on %q, was actually %q on %q", op, path, pathErr.Op, pathErr.Path)
+	}
+	if !errors.Is(err, target) {
+		t.Errorf("expected %v, was actually %v", target, err)
+	}
+}
+
+// Second returns the error of a call that also returns a value, so that such
+// calls can be listed in tables.
+func Second[T any](_ T, err error) error {
+	return err
+}
+
+// ExpectNames checks that names holds the expected names in any order.
+func ExpectNames(t testing.TB, expected []string, names []string) {
+	t.Helper()
+	sort.Strings(names)
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected entries %q, was actually %q", expected, names)
+	}
+}
@@ -0,0 +1,25 @@
+package util
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// CallSite returns the location of the innermost caller whose function name
+// does not start with skipPrefix, formatted as "function (file:line)". It
+// returns an empty string if the call stack is not available.
+func CallSite(skipPrefix string) string {
+	var pcs [16]uintptr
+	n := runtime.Callers(2, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if frame.Function != "" && !strings.HasPrefix(frame.Function, skipPrefix) {
+			return fmt.Sprintf("%s (%s:%d)", frame.Function, frame.File, frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
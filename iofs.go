@@ -0,0 +1,302 @@
+package tinyfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IOFS adapts a Filesystem to the interfaces defined by the standard library
+// io/fs package, so that a mounted filesystem can be handed to code such as
+// http.FileServer, template.ParseFS or fs.WalkDir.
+type IOFS struct {
+	fsys Filesystem
+	dir  string
+}
+
+var (
+	_ fs.FS         = (*IOFS)(nil)
+	_ fs.StatFS     = (*IOFS)(nil)
+	_ fs.ReadDirFS  = (*IOFS)(nil)
+	_ fs.ReadFileFS = (*IOFS)(nil)
+	_ fs.SubFS      = (*IOFS)(nil)
+)
+
+// NewIOFS returns an io/fs view of the root directory of the given filesystem,
+// which must already be mounted.
+func NewIOFS(fsys Filesystem) *IOFS {
+	return &IOFS{fsys: fsys, dir: "/"}
+}
+
+// fullpath validates an io/fs path name and translates it into a path on the
+// underlying filesystem. Backslashes are rejected because some filesystems
+// (such as FAT) treat them as path separators.
+func (f *IOFS) fullpath(op string, name string) (string, error) {
+	if !fs.ValidPath(name) || strings.ContainsRune(name, '\\') {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return path.Join(f.dir, name), nil
+}
+
+// Open opens the named file.
+func (f *IOFS) Open(name string) (fs.File, error) {
+	full, err := f.fullpath("open", name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := f.fsys.Open(full)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	return &ioFile{fsys: f, file: file, name: name, path: full}, nil
+}
+
+// Stat returns a FileInfo describing the named file.
+func (f *IOFS) Stat(name string) (fs.FileInfo, error) {
+	full, err := f.fullpath("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return f.stat(name, full)
+}
+
+func (f *IOFS) stat(name string, full string) (fs.FileInfo, error) {
+	if full == "/" {
+		// not every filesystem is able to stat its root directory
+		return &rootInfo{}, nil
+	}
+	info, err := f.fsys.Stat(full)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return &ioFileInfo{FileInfo: info, name: path.Base(name)}, nil
+}
+
+// ReadDir reads the named directory and returns a list of directory entries
+// sorted by filename.
+func (f *IOFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	full, err := f.fullpath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := f.fsys.Open(full)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	defer dir.Close()
+	entries, err := readDirEntries(dir)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	return entries, nil
+}
+
+// ReadFile reads the named file and returns its contents.
+func (f *IOFS) ReadFile(name string) ([]byte, error) {
+	full, err := f.fullpath("read", name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := f.fsys.Open(full)
+	if err != nil {
+		return nil, pathError("read", name, err)
+	}
+	defer file.Close()
+	if file.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errIsDir}
+	}
+	var data []byte
+	if info, err := f.fsys.Stat(full); err == nil && info.Size() > 0 {
+		data = make([]byte, 0, info.Size())
+	}
+	buf := make([]byte, 512)
+	for {
+		n, err := file.Read(buf)
+		data = append(data, buf[:n]...)
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, pathError("read", name, err)
+		}
+	}
+}
+
+// Sub returns an IOFS corresponding to the subtree rooted at dir.
+func (f *IOFS) Sub(dir string) (fs.FS, error) {
+	full, err := f.fullpath("sub", dir)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.stat(dir, full)
+	if err != nil {
+		return nil, pathError("sub", dir, err)
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: errNotDir}
+	}
+	return &IOFS{fsys: f.fsys, dir: full}, nil
+}
+
+var (
+	errIsDir  = errors.New("is a directory")
+	errNotDir = errors.New("not a directory")
+)
+
+// pathError wraps err in an *fs.PathError for the given operation and path,
+// unless it already carries that information.
+func pathError(op string, name string, err error) error {
+	if pe, ok := err.(*fs.PathError); ok {
+		err = pe.Err
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// dirEntryReader is implemented by files that can list a directory without
+// looking up the full FileInfo of every entry.
+type dirEntryReader interface {
+	ReadDir(n int) ([]fs.DirEntry, error)
+}
+
+// readDirEntries reads all remaining entries from dir and returns them sorted
+// by filename.
+func readDirEntries(dir File) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	if r, ok := dir.(dirEntryReader); ok {
+		var err error
+		if entries, err = r.ReadDir(0); err != nil {
+			return nil, err
+		}
+	} else {
+		infos, err := dir.Readdir(0)
+		if err != nil {
+			return nil, err
+		}
+		entries = make([]fs.DirEntry, 0, len(infos))
+		for _, info := range infos {
+			entries = append(entries, fs.FileInfoToDirEntry(info))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// ioFile implements fs.File and fs.ReadDirFile on top of a File.
+type ioFile struct {
+	fsys    *IOFS
+	file    File
+	name    string
+	path    string
+	entries []fs.DirEntry
+	read    bool
+}
+
+var (
+	_ fs.ReadDirFile = (*ioFile)(nil)
+	_ io.ReaderAt    = (*ioFile)(nil)
+	_ io.Seeker      = (*ioFile)(nil)
+)
+
+func (f *ioFile) Stat() (fs.FileInfo, error) {
+	return f.fsys.stat(f.name, f.path)
+}
+
+func (f *ioFile) Read(buf []byte) (int, error) {
+	if f.file.IsDir() {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: errIsDir}
+	}
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	n, err := f.file.Read(buf)
+	if err != nil && err != io.EOF {
+		err = pathError("read", f.name, err)
+	}
+	return n, err
+}
+
+func (f *ioFile) ReadAt(buf []byte, off int64) (int, error) {
+	if f.file.IsDir() {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: errIsDir}
+	}
+	n, err := f.file.ReadAt(buf, off)
+	if err != nil && err != io.EOF {
+		err = pathError("read", f.name, err)
+	}
+	return n, err
+}
+
+func (f *ioFile) Seek(offset int64, whence int) (int64, error) {
+	if f.file.IsDir() {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: errIsDir}
+	}
+	ret, err := f.file.Seek(offset, whence)
+	if err != nil {
+		return ret, pathError("seek", f.name, err)
+	}
+	return ret, nil
+}
+
+func (f *ioFile) Close() error {
+	if err := f.file.Close(); err != nil {
+		return pathError("close", f.name, err)
+	}
+	return nil
+}
+
+// ReadDir follows the contract of fs.ReadDirFile: with n > 0 at most n entries
+// are returned, and io.EOF is returned once the directory is exhausted.
+func (f *ioFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !f.file.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: errNotDir}
+	}
+	if !f.read {
+		entries, err := readDirEntries(f.file)
+		if err != nil {
+			return nil, pathError("readdir", f.name, err)
+		}
+		f.entries, f.read = entries, true
+	}
+	if n <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(f.entries) {
+		n = len(f.entries)
+	}
+	entries := f.entries[:n:n]
+	f.entries = f.entries[n:]
+	return entries, nil
+}
+
+// ioFileInfo overrides the name reported by the underlying filesystem with
+// the base name of the path it was looked up with, as io/fs requires.
+type ioFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (info *ioFileInfo) Name() string {
+	return info.name
+}
+
+// rootInfo describes the root directory of a filesystem.
+type rootInfo struct{}
+
+func (*rootInfo) Name() string       { return "." }
+func (*rootInfo) Size() int64        { return 0 }
+func (*rootInfo) Mode() os.FileMode  { return os.ModeDir | 0777 }
+func (*rootInfo) ModTime() time.Time { return time.Time{} }
+func (*rootInfo) IsDir() bool        { return true }
+func (*rootInfo) Sys() interface{}   { return nil }
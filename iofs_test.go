@@ -0,0 +1,117 @@
+package tinyfs_test
+
+import (
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"tinygo.org/x/tinyfs"
+	"tinygo.org/x/tinyfs/fatfs"
+	"tinygo.org/x/tinyfs/littlefs"
+)
+
+var testFiles = map[string]string{
+	"hello.txt":         "Hello World!",
+	"dir/a.txt":         "avocado",
+	"dir/sub/b.txt":     "burrito",
+	"dir/sub/empty.txt": "",
+}
+
+// filesystems returns constructors for an unformatted instance of each of the
+// filesystem drivers on top of a MemBlockDevice that enforces the rules of
+// flash memory. FAT overwrites sectors in place, so it needs an
+// EraseBlockCache in between.
+func filesystems() map[string]func() tinyfs.Filesystem {
+	return map[string]func() tinyfs.Filesystem{
+		"littlefs": func() tinyfs.Filesystem {
+			dev := tinyfs.NewMemoryDevice(64, 256, 2048).SetStrict(tinyfs.StrictNAND)
+			return littlefs.New(dev).Configure(&littlefs.Config{
+				CacheSize:     128,
+				LookaheadSize: 128,
+				BlockCycles:   500,
+			})
+		},
+		"fatfs": func() tinyfs.Filesystem {
+			dev := tinyfs.NewEraseBlockCache(tinyfs.NewMemoryDevice(64, 256, 4096).SetStrict(tinyfs.StrictNOR))
+			return fatfs.New(dev).Configure(&fatfs.Config{
+				SectorSize: fatfs.SectorSize,
+			})
+		},
+	}
+}
+
+// mountTestFS formats and mounts a new filesystem, which is unmounted again
+// when the test finishes.
+func mountTestFS(t *testing.T, newFS func() tinyfs.Filesystem) tinyfs.Filesystem {
+	filesystem := newFS()
+	if err := filesystem.Format(); err != nil {
+		t.Fatal(err)
+	}
+	if err := filesystem.Mount(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := filesystem.Unmount(); err != nil {
+			t.Error("Could not unmount", err)
+		}
+	})
+	return filesystem
+}
+
+func TestIOFS(t *testing.T) {
+	for name, newFS := range filesystems() {
+		t.Run(name, func(t *testing.T) {
+			testIOFS(t, mountTestFS(t, newFS))
+		})
+	}
+}
+
+func testIOFS(t *testing.T, filesystem tinyfs.Filesystem) {
+	for _, dir := range []string{"/dir", "/dir/sub", "/emptydir"} {
+		if err := filesystem.Mkdir(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, contents := range testFiles {
+		f, err := filesystem.OpenFile("/"+name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(contents) > 0 {
+			if _, err := f.Write([]byte(contents)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fsys := tinyfs.NewIOFS(filesystem)
+	if err := fstest.TestFS(fsys, "hello.txt", "dir/a.txt", "dir/sub/b.txt", "dir/sub/empty.txt", "emptydir"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("ReadFile", func(t *testing.T) {
+		for name, contents := range testFiles {
+			data, err := fs.ReadFile(fsys, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != contents {
+				t.Errorf("%s: expected %q, was actually %q", name, contents, data)
+			}
+		}
+	})
+
+	t.Run("PathError", func(t *testing.T) {
+		_, err := fsys.Open("missing.txt")
+		if _, ok := err.(*fs.PathError); !ok {
+			t.Fatalf("expected *fs.PathError, was actually %T: %v", err, err)
+		}
+		if _, err := fsys.Open("../escape"); err == nil {
+			t.Fatal("expected error opening invalid path")
+		}
+	})
+}
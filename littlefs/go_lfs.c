@@ -16,11 +16,36 @@ lfs_file_t* go_lfs_new_lfs_file() {
     return malloc(sizeof(lfs_file_t));
 }
 
-struct lfs_config* go_lfs_set_callbacks(struct lfs_config *cfg) {
-    cfg->read  = go_lfs_c_cb_read;
-    cfg->prog  = go_lfs_c_cb_prog;
-    cfg->erase = go_lfs_c_cb_erase;
-    cfg->sync  = go_lfs_c_cb_sync;
+struct lfs_file_config* go_lfs_new_lfs_file_config(lfs_size_t attr_count) {
+    struct lfs_file_config *cfg = calloc(1, sizeof(struct lfs_file_config) + attr_count*sizeof(struct lfs_attr));
+    if (cfg != NULL) {
+        cfg->attrs = (struct lfs_attr*)(cfg + 1);
+        cfg->attr_count = attr_count;
+    }
+    return cfg;
+}
+
+static int go_lfs_mark_block(void *data, lfs_block_t block) {
+    uint8_t *bitmap = data;
+    bitmap[block/8] |= 1 << (block%8);
+    return 0;
+}
+
+int go_lfs_block_usage(lfs_t *lfs, uint8_t *bitmap) {
+    return lfs_fs_traverse(lfs, go_lfs_mark_block, bitmap);
+}
+
+static int go_lfs_c_cb_nolock(const struct lfs_config *c) {
+    return 0;
+}
+
+struct lfs_config* go_lfs_set_callbacks(struct lfs_config *cfg, bool threadsafe) {
+    cfg->read   = go_lfs_c_cb_read;
+    cfg->prog   = go_lfs_c_cb_prog;
+    cfg->erase  = go_lfs_c_cb_erase;
+    cfg->sync   = go_lfs_c_cb_sync;
+    cfg->lock   = threadsafe ? go_lfs_c_cb_lock : go_lfs_c_cb_nolock;
+    cfg->unlock = threadsafe ? go_lfs_c_cb_unlock : go_lfs_c_cb_nolock;
     return cfg;
 }
 
@@ -39,3 +64,11 @@ int go_lfs_c_cb_erase(const struct lfs_config *c, lfs_block_t block) {
 int go_lfs_c_cb_sync(const struct lfs_config *c) {
 	return go_lfs_block_device_sync(c->context);
 }
+
+int go_lfs_c_cb_lock(const struct lfs_config *c) {
+	return go_lfs_lock(c->context);
+}
+
+int go_lfs_c_cb_unlock(const struct lfs_config *c) {
+	return go_lfs_unlock(c->context);
+}
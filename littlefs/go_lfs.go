@@ -6,13 +6,22 @@ package littlefs
 import "C"
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"math/bits"
 	"os"
+	"path"
+	"sort"
+	"sync"
 	"time"
 	"unsafe"
 
 	"tinygo.org/x/tinyfs"
+	"tinygo.org/x/tinyfs/internal/flock"
 	"tinygo.org/x/tinyfs/internal/gopointer"
 	"tinygo.org/x/tinyfs/internal/util"
 )
@@ -36,34 +45,60 @@ const (
 
 	fileTypeReg fileType = C.LFS_TYPE_REG
 	fileTypeDir fileType = C.LFS_TYPE_DIR
+
+	maxFileSize = C.LFS_FILE_MAX
+
+	accessModeMask = os.O_RDONLY | os.O_WRONLY | os.O_RDWR
+
+	// attrModTime is the type of the custom attribute that holds the
+	// modification time of a file or directory, stored as little-endian
+	// nanoseconds since the Unix epoch.
+	attrModTime = 't'
 )
 
-func translateFlags(osFlags int) C.int {
-	var result C.int
-	if osFlags&os.O_RDONLY > 0 {
-		result |= C.LFS_O_RDONLY
-	}
-	if osFlags&os.O_WRONLY > 0 {
-		result |= C.LFS_O_WRONLY
+// translateFlags translates osFlags such as os.O_RDONLY into littlefs flags.
+// littlefs has no equivalent of os.O_SYNC; File emulates it. Combinations that
+// make no sense, such as os.O_TRUNC with os.O_RDONLY, are rejected.
+func translateFlags(osFlags int) (C.int, error) {
+	if osFlags&^(accessModeMask|os.O_APPEND|os.O_CREATE|os.O_EXCL|os.O_SYNC|os.O_TRUNC) != 0 {
+		return 0, errInvalidParam
 	}
-	if osFlags&os.O_RDWR > 0 {
-		result |= C.LFS_O_RDWR
+	var result C.int
+	switch osFlags & accessModeMask {
+	case os.O_RDONLY:
+		if osFlags&os.O_TRUNC != 0 {
+			return 0, errInvalidParam
+		}
+		result = C.LFS_O_RDONLY
+	case os.O_WRONLY:
+		result = C.LFS_O_WRONLY
+	case os.O_RDWR:
+		result = C.LFS_O_RDWR
+	default:
+		return 0, errInvalidParam
 	}
-	if osFlags&os.O_CREATE > 0 {
+	if osFlags&os.O_CREATE != 0 {
 		result |= C.LFS_O_CREAT
+		if osFlags&os.O_EXCL != 0 {
+			result |= C.LFS_O_EXCL
+		}
 	}
-	if osFlags&os.O_EXCL > 0 {
-		result |= C.LFS_O_EXCL
-	}
-	if osFlags&os.O_TRUNC > 0 {
+	if osFlags&os.O_TRUNC != 0 {
 		result |= C.LFS_O_TRUNC
 	}
-	if osFlags&os.O_APPEND > 0 {
+	if osFlags&os.O_APPEND != 0 {
 		result |= C.LFS_O_APPEND
 	}
-	return result
+	return result, nil
 }
 
+var errNotConfigured = errors.New("littlefs: filesystem is not configured")
+
+// errAccessMode is returned when reading from a file opened with os.O_WRONLY
+// or writing to one opened with os.O_RDONLY. littlefs only checks this with
+// assertions, which are disabled in this build.
+var errAccessMode = fmt.Errorf("littlefs: operation not permitted by open mode: %w", fs.ErrPermission)
+
 type fileType uint
 
 type Error int
@@ -103,20 +138,148 @@ func (err Error) Error() string {
 	}
 }
 
+// Is reports whether err matches one of the portable error values in the io/fs
+// package, so that errors.Is(err, fs.ErrNotExist) and friends can be used
+// without knowledge of littlefs error codes.
+func (err Error) Is(target error) bool {
+	switch target {
+	case fs.ErrNotExist:
+		return err == errNoEntry
+	case fs.ErrExist:
+		return err == errEntryExists || err == errDirNotEmpty
+	case fs.ErrInvalid:
+		return err == errInvalidParam || err == errNameTooLong
+	case fs.ErrClosed:
+		return err == errBadFileNum
+	case tinyfs.ErrNoSpace:
+		return err == errNoSpace
+	}
+	return false
+}
+
+// Config holds the parameters of a littlefs instance, mirroring struct
+// lfs_config. The geometry fields default to values derived from the block
+// device when left at zero, as do the optional limits.
 type Config struct {
-	//ReadSize      uint32
-	//ProgSize      uint32
-	//BlockSize     uint32
-	//BlockCount    uint32
-	CacheSize     uint32
+	// ReadSize is the minimum size of a block read in bytes; all reads are a
+	// multiple of it. Defaults to the WriteBlockSize of the device.
+	ReadSize uint32
+
+	// ProgSize is the minimum size of a block program in bytes; all programs
+	// are a multiple of it. Defaults to the WriteBlockSize of the device.
+	ProgSize uint32
+
+	// BlockSize is the size of a logical block in bytes. It must be a multiple
+	// of the EraseBlockSize of the device, which is the default.
+	BlockSize uint32
+
+	// BlockCount is the number of logical blocks used by the filesystem,
+	// starting at the beginning of the device. Defaults to the whole device.
+	BlockCount uint32
+
+	// CacheSize is the size of each of the block caches in bytes. It must be
+	// a multiple of ReadSize and ProgSize, and a factor of BlockSize.
+	CacheSize uint32
+
+	// LookaheadSize is the size of the block allocation lookahead buffer in
+	// bytes. It must be a multiple of 8.
 	LookaheadSize uint32
-	BlockCycles   int32
+
+	// BlockCycles is the number of erase cycles before metadata is moved to
+	// another block; -1 disables block-level wear-levelling. Must not be 0.
+	BlockCycles int32
+
+	// NameMax is the maximum length of file names in bytes, at most 255.
+	// It is stored in the superblock. Defaults to 255.
+	NameMax uint32
+
+	// FileMax is the maximum size of files in bytes, at most 2147483647.
+	// It is stored in the superblock. Defaults to 2147483647.
+	FileMax uint32
+
+	// AttrMax is the maximum size of custom attributes in bytes, at most
+	// 1022. Defaults to 1022.
+	AttrMax uint32
+
+	// MetadataMax bounds the space used by each metadata pair in bytes, which
+	// bounds compaction time on devices with large blocks. It must not exceed
+	// BlockSize, which is the default.
+	MetadataMax uint32
+
+	// ThreadSafe makes it safe to use the filesystem and its files from
+	// several goroutines at once. littlefs then serializes its operations
+	// through a mutex, and each file serializes the operations that depend
+	// on its offset. Mount, Unmount, Format and Close must still not be
+	// called concurrently with other operations.
+	ThreadSafe bool
+
+	// MaxOpenFiles limits the number of files and directories that can be
+	// open on the filesystem at once. Opening more fails with an error
+	// matching tinyfs.ErrTooManyOpenFiles. Zero means no limit.
+	MaxOpenFiles int
+}
+
+// resolve returns a copy of the configuration with the defaults for dev
+// filled in, or an error if the configuration is invalid. The checks mirror
+// the assertions in lfs_init, which would otherwise crash the program.
+func (c *Config) resolve(dev tinyfs.BlockDevice) (Config, error) {
+	cfg := *c
+	if cfg.ReadSize == 0 {
+		cfg.ReadSize = uint32(dev.WriteBlockSize())
+	}
+	if cfg.ProgSize == 0 {
+		cfg.ProgSize = uint32(dev.WriteBlockSize())
+	}
+	if cfg.BlockSize == 0 {
+		cfg.BlockSize = uint32(dev.EraseBlockSize())
+	}
+	if cfg.BlockSize == 0 || cfg.BlockSize%uint32(dev.EraseBlockSize()) != 0 {
+		return cfg, fmt.Errorf("littlefs: block size (%d) must be a multiple of the erase block size of the device (%d)", cfg.BlockSize, dev.EraseBlockSize())
+	}
+	if cfg.BlockCount == 0 {
+		cfg.BlockCount = uint32(dev.Size() / int64(cfg.BlockSize))
+	}
+
+	switch {
+	case cfg.ReadSize == 0:
+		return cfg, errors.New("littlefs: read size must not be 0")
+	case cfg.ProgSize == 0:
+		return cfg, errors.New("littlefs: program size must not be 0")
+	case cfg.CacheSize == 0:
+		return cfg, errors.New("littlefs: cache size must not be 0")
+	case cfg.CacheSize%cfg.ReadSize != 0:
+		return cfg, fmt.Errorf("littlefs: cache size (%d) must be a multiple of the read size (%d)", cfg.CacheSize, cfg.ReadSize)
+	case cfg.CacheSize%cfg.ProgSize != 0:
+		return cfg, fmt.Errorf("littlefs: cache size (%d) must be a multiple of the program size (%d)", cfg.CacheSize, cfg.ProgSize)
+	case cfg.BlockSize%cfg.CacheSize != 0:
+		return cfg, fmt.Errorf("littlefs: block size (%d) must be a multiple of the cache size (%d)", cfg.BlockSize, cfg.CacheSize)
+	case cfg.BlockSize < 128:
+		return cfg, fmt.Errorf("littlefs: block size (%d) must be at least 128", cfg.BlockSize)
+	case cfg.BlockCount < 2:
+		return cfg, fmt.Errorf("littlefs: block count (%d) must be at least 2", cfg.BlockCount)
+	case int64(cfg.BlockSize)*int64(cfg.BlockCount) > dev.Size():
+		return cfg, fmt.Errorf("littlefs: %d blocks of %d bytes do not fit on a device of %d bytes", cfg.BlockCount, cfg.BlockSize, dev.Size())
+	case cfg.BlockCycles == 0:
+		return cfg, errors.New("littlefs: block cycles must not be 0; use -1 to disable wear-levelling")
+	case cfg.LookaheadSize == 0 || cfg.LookaheadSize%8 != 0:
+		return cfg, fmt.Errorf("littlefs: lookahead size (%d) must be a non-zero multiple of 8", cfg.LookaheadSize)
+	case cfg.NameMax > C.LFS_NAME_MAX:
+		return cfg, fmt.Errorf("littlefs: name max (%d) must not exceed %d", cfg.NameMax, C.LFS_NAME_MAX)
+	case cfg.FileMax > C.LFS_FILE_MAX:
+		return cfg, fmt.Errorf("littlefs: file max (%d) must not exceed %d", cfg.FileMax, C.LFS_FILE_MAX)
+	case cfg.AttrMax > C.LFS_ATTR_MAX:
+		return cfg, fmt.Errorf("littlefs: attr max (%d) must not exceed %d", cfg.AttrMax, C.LFS_ATTR_MAX)
+	case cfg.MetadataMax > cfg.BlockSize:
+		return cfg, fmt.Errorf("littlefs: metadata max (%d) must not exceed the block size (%d)", cfg.MetadataMax, cfg.BlockSize)
+	}
+	return cfg, nil
 }
 
 type Info struct {
-	ftyp fileType
-	size uint32
-	name string
+	ftyp  fileType
+	size  uint32
+	name  string
+	mtime time.Time
 }
 
 func (info *Info) Name() string {
@@ -143,15 +306,30 @@ func (info *Info) Mode() os.FileMode {
 	return v
 }
 
+// ModTime returns the modification time stored alongside the file, or the zero
+// time if the file has none (for instance when it was written by another
+// littlefs implementation).
 func (info *Info) ModTime() time.Time {
-	return time.Time{}
+	return info.mtime
 }
 
 type LFS struct {
 	dev tinyfs.BlockDevice
-	ptr unsafe.Pointer
 	lfs *C.struct_lfs
 	cfg *C.struct_lfs_config
+	err error
+
+	mounted      bool
+	files        map[*File]struct{}
+	filesMu      sync.Mutex
+	maxOpenFiles int
+
+	// locks holds the advisory locks of the open files
+	locks flock.Table
+
+	// mu is locked by littlefs around every operation in thread-safe mode
+	mu         sync.Mutex
+	threadSafe bool
 }
 
 func New(blockdev tinyfs.BlockDevice) *LFS {
@@ -160,20 +338,34 @@ func New(blockdev tinyfs.BlockDevice) *LFS {
 	}
 }
 
+// Configure sets up the filesystem with the given configuration. An invalid
+// configuration is reported by the next call to Format or Mount. If the
+// filesystem was configured before, it is closed first.
 func (l *LFS) Configure(config *Config) *LFS {
+	l.Close()
+	cfg, err := config.resolve(l.dev)
+	if l.err = err; err != nil {
+		return l
+	}
 	l.lfs = C.go_lfs_new_lfs()
 	l.cfg = C.go_lfs_new_lfs_config()
 	*l.cfg = C.struct_lfs_config{
 		context:        gopointer.Save(l),
-		read_size:      C.lfs_size_t(l.dev.WriteBlockSize()),
-		prog_size:      C.lfs_size_t(l.dev.WriteBlockSize()),
-		block_size:     C.lfs_size_t(l.dev.EraseBlockSize()),
-		block_count:    C.lfs_size_t(l.dev.Size() / l.dev.EraseBlockSize()),
-		cache_size:     C.lfs_size_t(config.CacheSize),
-		lookahead_size: C.lfs_size_t(config.LookaheadSize),
-		block_cycles:   C.int32_t(config.BlockCycles),
-	}
-	C.go_lfs_set_callbacks(l.cfg)
+		read_size:      C.lfs_size_t(cfg.ReadSize),
+		prog_size:      C.lfs_size_t(cfg.ProgSize),
+		block_size:     C.lfs_size_t(cfg.BlockSize),
+		block_count:    C.lfs_size_t(cfg.BlockCount),
+		cache_size:     C.lfs_size_t(cfg.CacheSize),
+		lookahead_size: C.lfs_size_t(cfg.LookaheadSize),
+		block_cycles:   C.int32_t(cfg.BlockCycles),
+		name_max:       C.lfs_size_t(cfg.NameMax),
+		file_max:       C.lfs_size_t(cfg.FileMax),
+		attr_max:       C.lfs_size_t(cfg.AttrMax),
+		metadata_max:   C.lfs_size_t(cfg.MetadataMax),
+	}
+	l.threadSafe = cfg.ThreadSafe
+	l.maxOpenFiles = cfg.MaxOpenFiles
+	C.go_lfs_set_callbacks(l.cfg, C.bool(cfg.ThreadSafe))
 	return l
 }
 
@@ -181,49 +373,229 @@ func (l *LFS) blockSize() uint32 {
 	return uint32(l.cfg.block_size)
 }
 
+// Mount mounts the filesystem. A filesystem that is already mounted is
+// unmounted first.
 func (l *LFS) Mount() error {
-	return errval(C.lfs_mount(l.lfs, l.cfg))
+	if err := l.configured(); err != nil {
+		return pathError("mount", "/", err)
+	}
+	if err := l.Unmount(); err != nil {
+		return err
+	}
+	if err := errval(C.lfs_mount(l.lfs, l.cfg)); err != nil {
+		return pathError("mount", "/", err)
+	}
+	l.mounted = true
+	return nil
 }
 
+// Format formats the block device, unmounting the filesystem first if it is
+// mounted.
 func (l *LFS) Format() error {
-	return errval(C.lfs_format(l.lfs, l.cfg))
+	if err := l.configured(); err != nil {
+		return pathError("format", "/", err)
+	}
+	if err := l.Unmount(); err != nil {
+		return err
+	}
+	return pathError("format", "/", errval(C.lfs_format(l.lfs, l.cfg)))
 }
 
+// Unmount unmounts the filesystem. It fails with tinyfs.ErrBusy if files or
+// directories are still open; OpenHandles lists them. Unmounting a filesystem
+// that is not mounted does nothing.
 func (l *LFS) Unmount() error {
-	return errval(C.lfs_unmount(l.lfs))
+	if !l.mounted {
+		return nil
+	}
+	if len(l.openFiles()) > 0 {
+		return pathError("unmount", "/", tinyfs.ErrBusy)
+	}
+	l.mounted = false
+	return pathError("unmount", "/", errval(C.lfs_unmount(l.lfs)))
+}
+
+// Close closes all files and directories that are still open, unmounts the
+// filesystem if it is mounted and frees the memory that was allocated by
+// Configure. Operations on the files that were closed fail with an error
+// matching fs.ErrClosed. The filesystem cannot be used again until it is
+// configured anew.
+func (l *LFS) Close() error {
+	var err error
+	for _, file := range l.openFiles() {
+		if cerr := file.Close(); err == nil {
+			err = pathError("close", file.name, cerr)
+		}
+	}
+	if uerr := l.Unmount(); err == nil {
+		err = uerr
+	}
+	if l.cfg != nil {
+		gopointer.Unref(l.cfg.context)
+		C.free(unsafe.Pointer(l.cfg))
+		l.cfg = nil
+	}
+	if l.lfs != nil {
+		C.free(unsafe.Pointer(l.lfs))
+		l.lfs = nil
+	}
+	return err
+}
+
+// OpenHandles returns the files and directories that are currently open on
+// the filesystem, sorted by path.
+func (l *LFS) OpenHandles() []tinyfs.OpenHandle {
+	files := l.openFiles()
+	handles := make([]tinyfs.OpenHandle, 0, len(files))
+	for _, file := range files {
+		handles = append(handles, tinyfs.OpenHandle{
+			Path:     file.name,
+			Flags:    file.flags,
+			CallSite: file.callSite,
+		})
+	}
+	sort.Slice(handles, func(i, j int) bool {
+		if handles[i].Path != handles[j].Path {
+			return handles[i].Path < handles[j].Path
+		}
+		return handles[i].CallSite < handles[j].CallSite
+	})
+	return handles
+}
+
+// openFiles returns the files and directories that are currently open.
+func (l *LFS) openFiles() []*File {
+	l.filesMu.Lock()
+	defer l.filesMu.Unlock()
+	files := make([]*File, 0, len(l.files))
+	for file := range l.files {
+		files = append(files, file)
+	}
+	return files
+}
+
+// addFile registers an open file, unless the limit on the number of open
+// files has been reached. It reports whether the file was registered.
+func (l *LFS) addFile(file *File) bool {
+	l.filesMu.Lock()
+	defer l.filesMu.Unlock()
+	if l.maxOpenFiles > 0 && len(l.files) >= l.maxOpenFiles {
+		return false
+	}
+	if l.files == nil {
+		l.files = make(map[*File]struct{})
+	}
+	l.files[file] = struct{}{}
+	return true
+}
+
+func (l *LFS) removeFile(file *File) {
+	l.filesMu.Lock()
+	defer l.filesMu.Unlock()
+	delete(l.files, file)
+}
+
+// configured returns the reason the filesystem cannot be formatted or
+// mounted, if any.
+func (l *LFS) configured() error {
+	if l.err != nil {
+		return l.err
+	}
+	if l.lfs == nil {
+		return errNotConfigured
+	}
+	return nil
+}
+
+// checkMounted returns tinyfs.ErrNotMounted unless the filesystem is mounted.
+// littlefs frees its caches on unmount and Close frees the rest of its state,
+// so nothing else may be passed to littlefs before this check.
+func (l *LFS) checkMounted() error {
+	if !l.mounted {
+		return tinyfs.ErrNotMounted
+	}
+	return nil
 }
 
 func (l *LFS) Remove(path string) error {
+	if err := l.checkMounted(); err != nil {
+		return pathError("remove", path, err)
+	}
 	cs := cstring(path)
 	defer C.free(unsafe.Pointer(cs))
-	return errval(C.lfs_remove(l.lfs, cs))
+	return pathError("remove", path, errval(C.lfs_remove(l.lfs, cs)))
 }
 
 func (l *LFS) Rename(oldPath string, newPath string) error {
+	if err := l.checkMounted(); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: err}
+	}
 	cs1, cs2 := cstring(oldPath), cstring(newPath)
 	defer C.free(unsafe.Pointer(cs1))
 	defer C.free(unsafe.Pointer(cs2))
-	return errval(C.lfs_rename(l.lfs, cs1, cs2))
+	if err := errval(C.lfs_rename(l.lfs, cs1, cs2)); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: err}
+	}
+	return nil
 }
 
 func (l *LFS) Stat(path string) (os.FileInfo, error) {
+	if err := l.checkMounted(); err != nil {
+		return nil, pathError("stat", path, err)
+	}
 	cs := cstring(path)
 	defer C.free(unsafe.Pointer(cs))
 	info := C.struct_lfs_info{}
 	if err := errval(C.lfs_stat(l.lfs, cs, &info)); err != nil {
-		return nil, err
+		return nil, pathError("stat", path, err)
 	}
 	return &Info{
-		ftyp: fileType(info._type),
-		size: uint32(info.size),
-		name: gostring(&info.name[0]),
+		ftyp:  fileType(info._type),
+		size:  uint32(info.size),
+		name:  gostring(&info.name[0]),
+		mtime: l.modTime(cs),
 	}, nil
 }
 
 func (l *LFS) Mkdir(path string, _ os.FileMode) error {
+	if err := l.checkMounted(); err != nil {
+		return pathError("mkdir", path, err)
+	}
 	cs := (*C.char)(cstring(path))
 	defer C.free(unsafe.Pointer(cs))
-	return errval(C.lfs_mkdir(l.lfs, cs))
+	if err := errval(C.lfs_mkdir(l.lfs, cs)); err != nil {
+		return pathError("mkdir", path, err)
+	}
+	return pathError("mkdir", path, l.setModTime(cs, time.Now()))
+}
+
+// Chtimes changes the modification time of the named file or directory. The
+// access time is not stored by littlefs and is ignored.
+func (l *LFS) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	if err := l.checkMounted(); err != nil {
+		return pathError("chtimes", path, err)
+	}
+	cs := cstring(path)
+	defer C.free(unsafe.Pointer(cs))
+	return pathError("chtimes", path, l.setModTime(cs, mtime))
+}
+
+// modTime reads the modification time attribute of the given path, returning
+// the zero time if it is missing.
+func (l *LFS) modTime(cs *C.char) time.Time {
+	var buf [8]byte
+	n := C.lfs_getattr(l.lfs, cs, attrModTime, unsafe.Pointer(&buf[0]), C.lfs_size_t(len(buf)))
+	if n != C.lfs_ssize_t(len(buf)) {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(binary.LittleEndian.Uint64(buf[:])))
+}
+
+// setModTime writes the modification time attribute of the given path.
+func (l *LFS) setModTime(cs *C.char, t time.Time) error {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(t.UnixNano()))
+	return errval(C.lfs_setattr(l.lfs, cs, attrModTime, unsafe.Pointer(&buf[0]), C.lfs_size_t(len(buf))))
 }
 
 func (l *LFS) Open(path string) (tinyfs.File, error) {
@@ -231,10 +603,44 @@ func (l *LFS) Open(path string) (tinyfs.File, error) {
 }
 
 func (l *LFS) OpenFile(path string, flags int) (tinyfs.File, error) {
+	return l.openFile(path, flags, nil)
+}
+
+// OpenFileWithAttrs opens the named file like OpenFile, together with a set
+// of custom attributes. If the file is opened with read access, each Buffer is
+// filled with the stored attribute, padded with zeros or truncated to fit;
+// attributes that are missing keep their contents. If the file is opened with
+// write access, the contents of the buffers are written out atomically with
+// the file data every time the file is synchronized or closed, so the buffers
+// must not be resized while the file is open.
+//
+// Directories are opened as with OpenFile and ignore attrs.
+func (l *LFS) OpenFileWithAttrs(path string, flags int, attrs []Attr) (tinyfs.File, error) {
+	for _, attr := range attrs {
+		if attr.Type == attrModTime {
+			return nil, pathError("open", path, errInvalidParam)
+		}
+	}
+	return l.openFile(path, flags, attrs)
+}
+
+func (l *LFS) openFile(path string, flags int, attrs []Attr) (tinyfs.File, error) {
+
+	lfsFlags, err := translateFlags(flags)
+	if err != nil {
+		return nil, pathError("open", path, err)
+	}
+	if err := l.checkMounted(); err != nil {
+		return nil, pathError("open", path, err)
+	}
 
 	cs := (*C.char)(cstring(path))
 	defer C.free(unsafe.Pointer(cs))
-	file := &File{lfs: l, name: path}
+	file := &File{lfs: l, name: path, flags: flags}
+	file.callSite = util.CallSite("tinygo.org/x/tinyfs/littlefs.(*LFS).")
+	if !l.addFile(file) {
+		return nil, pathError("open", path, tinyfs.ErrTooManyOpenFiles)
+	}
 
 	var ftype fileType
 	info := C.struct_lfs_info{}
@@ -242,15 +648,22 @@ func (l *LFS) OpenFile(path string, flags int) (tinyfs.File, error) {
 		ftype = fileType(info._type)
 	}
 
+	// a new or truncated file is modified even if it is never written to
+	file.dirty = flags&os.O_TRUNC != 0 || (ftype == 0 && flags&os.O_CREATE != 0)
+
 	var errno C.int
-	if ftype == fileTypeDir {
+	if ftype == fileTypeDir && flags&(accessModeMask|os.O_TRUNC) != os.O_RDONLY {
+		// directories can only be opened for reading
+		errno = C.LFS_ERR_ISDIR
+	} else if ftype == fileTypeDir {
 		file.typ = fileTypeDir
 		file.hndl = unsafe.Pointer(C.go_lfs_new_lfs_dir())
 		errno = C.lfs_dir_open(l.lfs, file.dirptr(), cs)
 	} else {
 		file.typ = fileTypeReg
 		file.hndl = unsafe.Pointer(C.go_lfs_new_lfs_file())
-		errno = C.lfs_file_open(l.lfs, file.fileptr(), cs, C.int(translateFlags(flags)))
+		file.newAttrs(attrs)
+		errno = C.lfs_file_opencfg(l.lfs, file.fileptr(), cs, lfsFlags, file.fcfg)
 	}
 
 	if err := errval(errno); err != nil {
@@ -258,12 +671,75 @@ func (l *LFS) OpenFile(path string, flags int) (tinyfs.File, error) {
 			C.free(file.hndl)
 			file.hndl = nil
 		}
-		return nil, err
+		file.freeAttrs()
+		l.removeFile(file)
+		return nil, pathError("open", path, err)
 	}
+	file.loadAttrs()
 
 	return file, nil
 }
 
+// Attr is a custom attribute of a file, identified by an 8-bit type and
+// limited in size to Config.AttrMax bytes. The type 't' is reserved for the
+// modification time of the file.
+type Attr struct {
+	Type   uint8
+	Buffer []byte
+}
+
+// GetAttr reads the custom attribute of the given type into buf, returning
+// the size of the stored attribute. If it is larger than buf, only the first
+// len(buf) bytes are read.
+func (l *LFS) GetAttr(path string, typ uint8, buf []byte) (int, error) {
+	if err := l.checkMounted(); err != nil {
+		return 0, pathError("getattr", path, err)
+	}
+	cs := cstring(path)
+	defer C.free(unsafe.Pointer(cs))
+	var ptr unsafe.Pointer
+	if len(buf) > 0 {
+		ptr = unsafe.Pointer(&buf[0])
+	}
+	n := C.lfs_getattr(l.lfs, cs, C.uint8_t(typ), ptr, C.lfs_size_t(len(buf)))
+	if n < 0 {
+		return 0, pathError("getattr", path, errval(C.int(n)))
+	}
+	return int(n), nil
+}
+
+// SetAttr stores buf as the custom attribute of the given type, replacing any
+// existing attribute of that type.
+func (l *LFS) SetAttr(path string, typ uint8, buf []byte) error {
+	if typ == attrModTime {
+		return pathError("setattr", path, errInvalidParam)
+	}
+	if err := l.checkMounted(); err != nil {
+		return pathError("setattr", path, err)
+	}
+	cs := cstring(path)
+	defer C.free(unsafe.Pointer(cs))
+	var ptr unsafe.Pointer
+	if len(buf) > 0 {
+		ptr = unsafe.Pointer(&buf[0])
+	}
+	return pathError("setattr", path, errval(C.lfs_setattr(l.lfs, cs, C.uint8_t(typ), ptr, C.lfs_size_t(len(buf)))))
+}
+
+// RemoveAttr removes the custom attribute of the given type. Removing an
+// attribute that does not exist is not an error.
+func (l *LFS) RemoveAttr(path string, typ uint8) error {
+	if typ == attrModTime {
+		return pathError("removeattr", path, errInvalidParam)
+	}
+	if err := l.checkMounted(); err != nil {
+		return pathError("removeattr", path, err)
+	}
+	cs := cstring(path)
+	defer C.free(unsafe.Pointer(cs))
+	return pathError("removeattr", path, errval(C.lfs_removeattr(l.lfs, cs, C.uint8_t(typ))))
+}
+
 // Size finds the current size of the filesystem
 //
 // Note: Result is best effort. If files share COW structures, the returned
@@ -271,18 +747,192 @@ func (l *LFS) OpenFile(path string, flags int) (tinyfs.File, error) {
 //
 // Returns the number of allocated blocks, or a negative error code on failure.
 func (l *LFS) Size() (n int, err error) {
+	if err := l.checkMounted(); err != nil {
+		return 0, pathError("size", "/", err)
+	}
 	errno := C.int(C.lfs_fs_size(l.lfs))
 	if errno < 0 {
-		return 0, errval(errno)
+		return 0, pathError("size", "/", errval(errno))
 	}
 	return int(errno), nil
 }
 
+var _ tinyfs.StatFS = (*LFS)(nil)
+
+// StatFS reports the capacity of the filesystem in logical blocks. Unlike
+// Size, blocks shared between files are only counted once.
+func (l *LFS) StatFS() (tinyfs.FSStat, error) {
+	info, err := l.FSStat()
+	if err != nil {
+		return tinyfs.FSStat{}, err
+	}
+	usage, err := l.BlockUsage()
+	if err != nil {
+		return tinyfs.FSStat{}, err
+	}
+	blockSize := int64(info.BlockSize)
+	total := int64(info.BlockCount) * blockSize
+	used := int64(usage.Count()) * blockSize
+	// directories grow by chaining metadata pairs, so MaxDirEntries is 0
+	return tinyfs.FSStat{
+		BlockSize:  blockSize,
+		TotalBytes: total,
+		FreeBytes:  total - used,
+		UsedBytes:  used,
+		NameMax:    int64(info.NameMax),
+	}, nil
+}
+
+// BlockUsage is a bitmap with one bit per logical block of a filesystem, which
+// is set if the block is in use. Logical block i occupies the erase blocks
+// starting at i*BlockSize/EraseBlockSize on the device.
+type BlockUsage []byte
+
+// InUse reports whether the given logical block is in use.
+func (u BlockUsage) InUse(block uint32) bool {
+	if int(block/8) >= len(u) {
+		return false
+	}
+	return u[block/8]&(1<<(block%8)) != 0
+}
+
+// Count returns the number of logical blocks in use.
+func (u BlockUsage) Count() int {
+	n := 0
+	for _, b := range u {
+		n += bits.OnesCount8(b)
+	}
+	return n
+}
+
+// BlockUsage traverses the filesystem and returns a bitmap of the logical
+// blocks that are in use by files, directories and metadata.
+func (l *LFS) BlockUsage() (BlockUsage, error) {
+	if err := l.checkMounted(); err != nil {
+		return nil, pathError("traverse", "/", err)
+	}
+	usage := make(BlockUsage, (l.cfg.block_count+7)/8)
+	if err := errval(C.go_lfs_block_usage(l.lfs, (*C.uint8_t)(unsafe.Pointer(&usage[0])))); err != nil {
+		return nil, pathError("traverse", "/", err)
+	}
+	return usage, nil
+}
+
+// FSInfo describes a mounted filesystem as recorded in its superblock.
+type FSInfo struct {
+	// DiskVersion is the on-disk version of the filesystem, with the major
+	// version in the upper 16 bits and the minor version in the lower 16 bits.
+	DiskVersion uint32
+
+	// BlockSize is the size of a logical block in bytes.
+	BlockSize uint32
+
+	// BlockCount is the number of logical blocks in the filesystem.
+	BlockCount uint32
+
+	// NameMax is the upper limit on the length of file names in bytes.
+	NameMax uint32
+
+	// FileMax is the upper limit on the size of files in bytes.
+	FileMax uint32
+
+	// AttrMax is the upper limit on the size of custom attributes in bytes.
+	AttrMax uint32
+}
+
+// FSStat returns information about the mounted filesystem as found on disk,
+// which may differ from the configuration it was mounted with when it was
+// formatted by an older version of littlefs or with other limits.
+func (l *LFS) FSStat() (FSInfo, error) {
+	if err := l.checkMounted(); err != nil {
+		return FSInfo{}, pathError("statfs", "/", err)
+	}
+	info := C.struct_lfs_fsinfo{}
+	if err := errval(C.lfs_fs_stat(l.lfs, &info)); err != nil {
+		return FSInfo{}, pathError("statfs", "/", err)
+	}
+	return FSInfo{
+		DiskVersion: uint32(info.disk_version),
+		BlockSize:   uint32(info.block_size),
+		BlockCount:  uint32(info.block_count),
+		NameMax:     uint32(info.name_max),
+		FileMax:     uint32(info.file_max),
+		AttrMax:     uint32(info.attr_max),
+	}, nil
+}
+
+// GC performs janitorial work that would otherwise happen on the next write,
+// such as populating the block allocator, so that it can be done at a
+// convenient time.
+func (l *LFS) GC() error {
+	if err := l.checkMounted(); err != nil {
+		return pathError("gc", "/", err)
+	}
+	return pathError("gc", "/", errval(C.lfs_fs_gc(l.lfs)))
+}
+
+// MakeConsistent completes any orphan removal or metadata repair left behind
+// by a power loss, which would otherwise happen on the first write after
+// mounting.
+func (l *LFS) MakeConsistent() error {
+	if err := l.checkMounted(); err != nil {
+		return pathError("mkconsistent", "/", err)
+	}
+	return pathError("mkconsistent", "/", errval(C.lfs_fs_mkconsistent(l.lfs)))
+}
+
+// Grow extends the mounted filesystem to blockCount logical blocks, updating
+// the superblock and the configuration used by later calls to Mount and
+// Format. The filesystem cannot be shrunk, and growing it is irreversible.
+func (l *LFS) Grow(blockCount uint32) error {
+	if err := l.checkMounted(); err != nil {
+		return pathError("grow", "/", err)
+	}
+	if blockCount < uint32(l.cfg.block_count) {
+		return pathError("grow", "/", errInvalidParam)
+	}
+	if int64(blockCount)*int64(l.blockSize()) > l.dev.Size() {
+		return pathError("grow", "/", errNoSpace)
+	}
+	if err := errval(C.lfs_fs_grow(l.lfs, C.lfs_size_t(blockCount))); err != nil {
+		return pathError("grow", "/", err)
+	}
+	l.cfg.block_count = C.lfs_size_t(blockCount)
+	return nil
+}
+
+var _ tinyfs.File = (*File)(nil)
+
 type File struct {
-	lfs  *LFS
-	typ  fileType
-	hndl unsafe.Pointer
-	name string
+	lfs   *LFS
+	typ   fileType
+	hndl  unsafe.Pointer
+	name  string
+	flags int
+	dirty bool
+
+	// callSite is where the file was opened, for OpenHandles
+	callSite string
+
+	// mu serializes the operations on the file in thread-safe mode
+	mu sync.Mutex
+
+	// custom attributes passed to OpenFileWithAttrs, and the file config
+	// holding their C copies
+	attrs []Attr
+	fcfg  *C.struct_lfs_file_config
+}
+
+func (f *File) lock() {
+	if f.lfs.threadSafe {
+		f.mu.Lock()
+	}
+}
+
+func (f *File) unlock() {
+	if f.lfs.threadSafe {
+		f.mu.Unlock()
+	}
 }
 
 func (f *File) dirptr() *C.struct_lfs_dir {
@@ -300,13 +950,19 @@ func (f *File) Name() string {
 
 // Close the file; any pending writes are written out to storage
 func (f *File) Close() error {
+	f.lock()
+	defer f.unlock()
 	if f.hndl != nil {
 		defer func() {
 			C.free(f.hndl)
 			f.hndl = nil
+			f.lfs.locks.Unlock(lockKey(f.name), f)
+			f.lfs.removeFile(f)
 		}()
 		switch f.typ {
 		case fileTypeReg:
+			defer f.freeAttrs()
+			f.storeAttrs()
 			return errval(C.lfs_file_close(f.lfs.lfs, f.fileptr()))
 		case fileTypeDir:
 			return errval(C.lfs_dir_close(f.lfs.lfs, f.dirptr()))
@@ -317,10 +973,84 @@ func (f *File) Close() error {
 	return nil
 }
 
+var _ tinyfs.Locker = (*File)(nil)
+
+// Lock places an advisory lock on the file, as described by tinyfs.Locker.
+// Locks are identified by the path the file was opened with, so they do not
+// follow the file when it is renamed.
+func (f *File) Lock(typ tinyfs.LockType) error {
+	return f.flock(typ, true)
+}
+
+// TryLock places an advisory lock on the file like Lock, but fails with
+// tinyfs.ErrLocked instead of waiting for a conflicting lock.
+func (f *File) TryLock(typ tinyfs.LockType) error {
+	return f.flock(typ, false)
+}
+
+// Unlock releases the advisory lock held through the file, if any.
+func (f *File) Unlock() error {
+	f.lock()
+	defer f.unlock()
+	if f.hndl == nil {
+		return errBadFileNum
+	}
+	f.lfs.locks.Unlock(lockKey(f.name), f)
+	return nil
+}
+
+// flock takes an advisory lock of the given type. The file itself is not
+// locked while waiting, so that it can still be used and closed meanwhile.
+func (f *File) flock(typ tinyfs.LockType, wait bool) error {
+	if typ != tinyfs.LockShared && typ != tinyfs.LockExclusive {
+		return errInvalidParam
+	}
+	if f.closed() {
+		return errBadFileNum
+	}
+	key := lockKey(f.name)
+	if !f.lfs.locks.Lock(key, f, typ == tinyfs.LockExclusive, wait) {
+		return tinyfs.ErrLocked
+	}
+	if f.closed() {
+		// closed while waiting, after Close released the locks of the file
+		f.lfs.locks.Unlock(key, f)
+		return errBadFileNum
+	}
+	return nil
+}
+
+func (f *File) closed() bool {
+	f.lock()
+	defer f.unlock()
+	return f.hndl == nil
+}
+
+// lockKey returns the key identifying a file in the lock table, which is the
+// same for every way of spelling its path.
+func lockKey(name string) string {
+	return path.Clean("/" + name)
+}
+
 func (f *File) Read(buf []byte) (n int, err error) {
+	f.lock()
+	defer f.unlock()
+	return f.read(buf)
+}
+
+func (f *File) read(buf []byte) (n int, err error) {
+	if f.hndl == nil {
+		return 0, errBadFileNum
+	}
 	if f.IsDir() {
 		return 0, errIsDir
 	}
+	if !f.readable() {
+		return 0, errAccessMode
+	}
+	if len(buf) == 0 {
+		return 0, nil
+	}
 	bufptr := unsafe.Pointer(&buf[0])
 	buflen := C.lfs_size_t(len(buf))
 	errno := C.int(C.lfs_file_read(f.lfs.lfs, f.fileptr(), bufptr, buflen))
@@ -334,8 +1064,56 @@ func (f *File) Read(buf []byte) (n int, err error) {
 	}
 }
 
+// ReadAt reads len(buf) bytes from the file starting at byte offset off. The
+// current position of the file is not affected.
+func (f *File) ReadAt(buf []byte, off int64) (n int, err error) {
+	f.lock()
+	defer f.unlock()
+	if f.hndl == nil {
+		return 0, errBadFileNum
+	}
+	if f.IsDir() {
+		return 0, errIsDir
+	}
+	if off < 0 {
+		return 0, errInvalidParam
+	}
+	pos, err := f.tell()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if _, serr := f.seek(pos, io.SeekStart); err == nil {
+			err = serr
+		}
+	}()
+	if _, err := f.seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	for n < len(buf) {
+		m, err := f.read(buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
 // Seek changes the position of the file
 func (f *File) Seek(offset int64, whence int) (ret int64, err error) {
+	f.lock()
+	defer f.unlock()
+	return f.seek(offset, whence)
+}
+
+func (f *File) seek(offset int64, whence int) (ret int64, err error) {
+	if f.hndl == nil {
+		return -1, errBadFileNum
+	}
+	if f.IsDir() {
+		return -1, errIsDir
+	}
 	errno := C.int(C.lfs_file_seek(f.lfs.lfs, f.fileptr(), C.lfs_soff_t(offset), C.int(whence)))
 	if errno < 0 {
 		return -1, errval(errno)
@@ -345,6 +1123,18 @@ func (f *File) Seek(offset int64, whence int) (ret int64, err error) {
 
 // Tell returns the position of the file
 func (f *File) Tell() (ret int64, err error) {
+	f.lock()
+	defer f.unlock()
+	return f.tell()
+}
+
+func (f *File) tell() (ret int64, err error) {
+	if f.hndl == nil {
+		return -1, errBadFileNum
+	}
+	if f.IsDir() {
+		return -1, errIsDir
+	}
 	errno := C.int(C.lfs_file_tell(f.lfs.lfs, f.fileptr()))
 	if errno < 0 {
 		return -1, errval(errno)
@@ -354,11 +1144,31 @@ func (f *File) Tell() (ret int64, err error) {
 
 // Rewind changes the position of the file to the beginning of the file
 func (f *File) Rewind() (err error) {
+	f.lock()
+	defer f.unlock()
+	if f.hndl == nil {
+		return errBadFileNum
+	}
+	if f.IsDir() {
+		return errIsDir
+	}
 	return errval(C.lfs_file_rewind(f.lfs.lfs, f.fileptr()))
 }
 
 // Size returns the size of the file
 func (f *File) Size() (int64, error) {
+	f.lock()
+	defer f.unlock()
+	return f.size()
+}
+
+func (f *File) size() (int64, error) {
+	if f.hndl == nil {
+		return -1, errBadFileNum
+	}
+	if f.IsDir() {
+		return 0, nil
+	}
 	errno := C.int(C.lfs_file_size(f.lfs.lfs, f.fileptr()))
 	if errno < 0 {
 		return -1, errval(errno)
@@ -366,58 +1176,408 @@ func (f *File) Size() (int64, error) {
 	return int64(errno), nil
 }
 
+// Stat returns the FileInfo structure describing the open file
+func (f *File) Stat() (os.FileInfo, error) {
+	f.lock()
+	defer f.unlock()
+	size, err := f.size()
+	if err != nil {
+		return nil, err
+	}
+	cs := cstring(f.name)
+	defer C.free(unsafe.Pointer(cs))
+	return &Info{
+		ftyp:  f.typ,
+		size:  uint32(size),
+		name:  path.Base(f.name),
+		mtime: f.lfs.modTime(cs),
+	}, nil
+}
+
 // Sync synchronizes to storage so that any pending writes are written out.
 func (f *File) Sync() error {
-	return errval(C.lfs_file_sync(f.lfs.lfs, f.fileptr()))
+	f.lock()
+	defer f.unlock()
+	return f.sync()
+}
+
+func (f *File) sync() error {
+	if f.hndl == nil {
+		return errBadFileNum
+	}
+	if f.IsDir() {
+		return nil
+	}
+	f.storeAttrs()
+	if err := errval(C.lfs_file_sync(f.lfs.lfs, f.fileptr())); err != nil {
+		return err
+	}
+	f.dirty = false
+	return nil
+}
+
+// newAttrs allocates the file config holding the C copies of the given
+// custom attributes, initialized with their current contents, followed by the
+// modification time. The latter is only included in the attribute count while
+// storeAttrs prepares a commit of a modified file, so that opening a file for
+// writing does not commit it by itself and the time is never read back.
+func (f *File) newAttrs(attrs []Attr) {
+	f.attrs = attrs
+	f.fcfg = C.go_lfs_new_lfs_file_config(C.lfs_size_t(len(attrs) + 1))
+	all := f.allAttrs()
+	for i, attr := range all[:len(attrs)] {
+		attr._type = C.uint8_t(attrs[i].Type)
+		attr.size = C.lfs_size_t(len(attrs[i].Buffer))
+		attr.buffer = C.calloc(1, C.size_t(len(attrs[i].Buffer)+1))
+		copy(attrBytes(attr), attrs[i].Buffer)
+	}
+	mtime := all[len(attrs)]
+	mtime._type = attrModTime
+	mtime.size = 8
+	mtime.buffer = C.calloc(1, 8)
+	f.fcfg.attr_count = C.lfs_size_t(len(attrs))
+}
+
+// allAttrs returns all attributes in the file config, whatever their count.
+func (f *File) allAttrs() []*C.struct_lfs_attr {
+	if f.fcfg == nil {
+		return nil
+	}
+	attrs := unsafe.Slice(f.fcfg.attrs, len(f.attrs)+1)
+	ptrs := make([]*C.struct_lfs_attr, len(attrs))
+	for i := range attrs {
+		ptrs[i] = &attrs[i]
+	}
+	return ptrs
+}
+
+// cattrs returns the custom attributes in the file config.
+func (f *File) cattrs() []*C.struct_lfs_attr {
+	if f.fcfg == nil {
+		return nil
+	}
+	return f.allAttrs()[:len(f.attrs)]
+}
+
+func attrBytes(attr *C.struct_lfs_attr) []byte {
+	return unsafe.Slice((*byte)(attr.buffer), attr.size)
+}
+
+// loadAttrs copies the custom attributes read by littlefs when the file was
+// opened into the buffers of the caller.
+func (f *File) loadAttrs() {
+	for i, attr := range f.cattrs() {
+		copy(f.attrs[i].Buffer, attrBytes(attr))
+	}
+}
+
+// readable reports whether the access mode of the file permits reading.
+func (f *File) readable() bool {
+	return f.flags&accessModeMask != os.O_WRONLY
+}
+
+// writable reports whether the access mode of the file permits writing.
+func (f *File) writable() bool {
+	return f.flags&accessModeMask != os.O_RDONLY
+}
+
+// storeAttrs copies the buffers of the caller into the custom attributes that
+// littlefs writes out on the next sync, and adds the current time as the
+// modification time if the file has been modified since it was opened or last
+// synchronized, so that data, attributes and time are committed together.
+// littlefs only commits files that have been modified, so the file is marked
+// as such if any of the attributes changed or the file was truncated to its
+// own size.
+func (f *File) storeAttrs() {
+	if !f.writable() {
+		return
+	}
+	dirty := f.dirty
+	for i, attr := range f.cattrs() {
+		if buf := attrBytes(attr); !bytes.Equal(buf, f.attrs[i].Buffer) {
+			copy(buf, f.attrs[i].Buffer)
+			dirty = true
+		}
+	}
+	f.fcfg.attr_count = C.lfs_size_t(len(f.attrs))
+	if f.dirty {
+		mtime := f.allAttrs()[len(f.attrs)]
+		binary.LittleEndian.PutUint64(attrBytes(mtime), uint64(time.Now().UnixNano()))
+		f.fcfg.attr_count++
+	}
+	if dirty {
+		f.markDirty()
+	}
+}
+
+// markDirty sets the flag that makes littlefs commit the file on its next
+// sync. littlefs also updates the flags of every open file when it commits
+// another one, so in thread-safe mode this must hold its lock.
+func (f *File) markDirty() {
+	if f.lfs.threadSafe {
+		f.lfs.mu.Lock()
+		defer f.lfs.mu.Unlock()
+	}
+	f.fileptr().flags |= C.LFS_F_DIRTY
+}
+
+// freeAttrs releases the file config and the C copies of the attributes.
+func (f *File) freeAttrs() {
+	for _, attr := range f.allAttrs() {
+		C.free(attr.buffer)
+	}
+	if f.fcfg != nil {
+		C.free(unsafe.Pointer(f.fcfg))
+		f.fcfg = nil
+	}
+	f.attrs = nil
 }
 
 // Truncate the size of the file to the specified size
-func (f *File) Truncate(size uint32) error {
-	return errval(C.lfs_file_truncate(f.lfs.lfs, f.fileptr(), C.lfs_off_t(size)))
+func (f *File) Truncate(size int64) error {
+	f.lock()
+	defer f.unlock()
+	if f.hndl == nil {
+		return errBadFileNum
+	}
+	if f.IsDir() {
+		return errIsDir
+	}
+	if !f.writable() {
+		return errAccessMode
+	}
+	if size < 0 || size > maxFileSize {
+		return errInvalidParam
+	}
+	if err := errval(C.lfs_file_truncate(f.lfs.lfs, f.fileptr(), C.lfs_off_t(size))); err != nil {
+		return err
+	}
+	f.dirty = true
+	return nil
 }
 
 func (f *File) Write(buf []byte) (n int, err error) {
+	f.lock()
+	defer f.unlock()
+	return f.write(buf)
+}
+
+func (f *File) write(buf []byte) (n int, err error) {
+	if f.hndl == nil {
+		return 0, errBadFileNum
+	}
+	if f.IsDir() {
+		return 0, errIsDir
+	}
+	if !f.writable() {
+		return 0, errAccessMode
+	}
+	if len(buf) == 0 {
+		return 0, nil
+	}
 	bufptr := unsafe.Pointer(&buf[0])
 	buflen := C.lfs_size_t(len(buf))
 	errno := C.lfs_file_write(f.lfs.lfs, f.fileptr(), bufptr, buflen)
 	if errno > 0 {
+		f.dirty = true
+		if f.flags&os.O_SYNC != 0 {
+			return int(errno), f.sync()
+		}
 		return int(errno), nil
 	} else {
 		return 0, errval(C.int(errno))
 	}
 }
 
+// WriteAt writes len(buf) bytes to the file starting at byte offset off. The
+// current position of the file is not affected. WriteAt is not permitted on
+// files opened with os.O_APPEND, as littlefs always appends to those.
+func (f *File) WriteAt(buf []byte, off int64) (n int, err error) {
+	f.lock()
+	defer f.unlock()
+	if f.hndl == nil {
+		return 0, errBadFileNum
+	}
+	if f.IsDir() {
+		return 0, errIsDir
+	}
+	if off < 0 {
+		return 0, errInvalidParam
+	}
+	if !f.writable() {
+		return 0, errAccessMode
+	}
+	if f.flags&os.O_APPEND != 0 {
+		return 0, errors.New("littlefs: WriteAt not permitted on file opened with O_APPEND")
+	}
+	pos, err := f.tell()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if _, serr := f.seek(pos, io.SeekStart); err == nil {
+			err = serr
+		}
+	}()
+	if _, err := f.seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	for n < len(buf) {
+		m, err := f.write(buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
 func (f *File) IsDir() bool {
 	return f.typ == fileTypeDir
 }
 
+// Readdir reads the contents of the directory and returns up to n FileInfo
+// values, in directory order, like os.File.Readdir. If n > 0, it returns an
+// empty slice and io.EOF once the end of the directory is reached. If n <= 0,
+// it returns all remaining entries and a nil error at the end.
 func (f *File) Readdir(n int) (infos []os.FileInfo, err error) {
-	if n > 0 {
-		return nil, errors.New("n > 0 is not supported yet")
+	f.lock()
+	defer f.unlock()
+	err = f.readdir(n, func(info *Info) {
+		cs := cstring(path.Join(f.name, info.name))
+		info.mtime = f.lfs.modTime(cs)
+		C.free(unsafe.Pointer(cs))
+		infos = append(infos, info)
+	})
+	return infos, err
+}
+
+// ReadDir reads the contents of the directory like Readdir, but returns
+// fs.DirEntry values, which only look up the modification time of an entry
+// when its Info method is called.
+func (f *File) ReadDir(n int) (entries []fs.DirEntry, err error) {
+	f.lock()
+	defer f.unlock()
+	err = f.readdir(n, func(info *Info) {
+		entries = append(entries, &dirEntry{
+			info: info,
+			path: path.Join(f.name, info.name),
+			lfs:  f.lfs,
+		})
+	})
+	return entries, err
+}
+
+// readdir calls fn for up to n entries of the directory, or for all remaining
+// entries if n <= 0, without their modification time.
+func (f *File) readdir(n int, fn func(info *Info)) error {
+	if f.hndl == nil {
+		return errBadFileNum
 	}
 	if !f.IsDir() {
-		return nil, errNotDir
+		return errNotDir
 	}
-	for {
+	count := 0
+	for n <= 0 || count < n {
 		var info C.struct_lfs_info
 		i := C.lfs_dir_read(f.lfs.lfs, f.dirptr(), &info)
-		if i == 0 {
-			return
-		}
 		if i < 0 {
-			err = errval(C.int(i))
-			return
+			return errval(C.int(i))
+		}
+		if i == 0 {
+			break
 		}
 		name := gostring(&info.name[0])
 		if name == "." || name == ".." {
 			continue // littlefs returns . and .., but Readdir() in Go does not
 		}
-		infos = append(infos, &Info{
+		fn(&Info{
 			ftyp: fileType(info._type),
 			size: uint32(info.size),
 			name: name,
 		})
+		count++
+	}
+	if n > 0 && count == 0 {
+		return io.EOF
 	}
+	return nil
+}
+
+// TellDir returns the current position in the directory listing, which can
+// be passed to SeekDir to resume reading the directory from there. It is an
+// opaque value rather than a number of entries.
+func (f *File) TellDir() (int64, error) {
+	f.lock()
+	defer f.unlock()
+	if f.hndl == nil {
+		return -1, errBadFileNum
+	}
+	if !f.IsDir() {
+		return -1, errNotDir
+	}
+	off := C.lfs_dir_tell(f.lfs.lfs, f.dirptr())
+	if off < 0 {
+		return -1, errval(C.int(off))
+	}
+	return int64(off), nil
+}
+
+// SeekDir changes the position in the directory listing to off, as returned
+// by TellDir on a directory opened on the same path.
+func (f *File) SeekDir(off int64) error {
+	f.lock()
+	defer f.unlock()
+	if f.hndl == nil {
+		return errBadFileNum
+	}
+	if !f.IsDir() {
+		return errNotDir
+	}
+	if off < 0 {
+		return errInvalidParam
+	}
+	return errval(C.lfs_dir_seek(f.lfs.lfs, f.dirptr(), C.lfs_off_t(off)))
+}
+
+// RewindDir changes the position in the directory listing to its beginning.
+func (f *File) RewindDir() error {
+	f.lock()
+	defer f.unlock()
+	if f.hndl == nil {
+		return errBadFileNum
+	}
+	if !f.IsDir() {
+		return errNotDir
+	}
+	return errval(C.lfs_dir_rewind(f.lfs.lfs, f.dirptr()))
+}
+
+// dirEntry is the fs.DirEntry returned by ReadDir.
+type dirEntry struct {
+	info *Info
+	path string
+	lfs  *LFS
+}
+
+var _ fs.DirEntry = (*dirEntry)(nil)
+
+func (e *dirEntry) Name() string {
+	return e.info.name
+}
+
+func (e *dirEntry) IsDir() bool {
+	return e.info.IsDir()
+}
+
+func (e *dirEntry) Type() fs.FileMode {
+	return e.info.Mode().Type()
+}
+
+// Info returns the FileInfo of the entry as of the call, which fails with an
+// error matching fs.ErrNotExist if it has been removed since.
+func (e *dirEntry) Info() (fs.FileInfo, error) {
+	return e.lfs.Stat(e.path)
 }
 
 func errval(errno C.int) error {
@@ -427,6 +1587,15 @@ func errval(errno C.int) error {
 	return nil
 }
 
+// pathError wraps a non-nil err in an *os.PathError recording the operation
+// and the path it was performed on.
+func pathError(op string, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &os.PathError{Op: op, Path: path, Err: err}
+}
+
 func cstring(s string) *C.char {
 	return (*C.char)(util.CString(s))
 }
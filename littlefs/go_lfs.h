@@ -11,6 +11,8 @@ extern int go_lfs_block_device_read(void*, lfs_block_t, lfs_off_t, void*, lfs_si
 extern int go_lfs_block_device_prog(void*, lfs_block_t, lfs_off_t, const void*, lfs_size_t);
 extern int go_lfs_block_device_erase(void*, lfs_block_t);
 extern int go_lfs_block_device_sync(void*);
+extern int go_lfs_lock(void*);
+extern int go_lfs_unlock(void*);
 
 // These are the global C callbacks. Pointers to these functions are passed to
 // the LittleFS library as the block device callbacks, and they in turn call
@@ -20,6 +22,8 @@ int go_lfs_c_cb_read(const struct lfs_config *c, lfs_block_t block, lfs_off_t of
 int go_lfs_c_cb_prog(const struct lfs_config *c, lfs_block_t block, lfs_off_t off, const void *buffer, lfs_size_t size);
 int go_lfs_c_cb_erase(const struct lfs_config *c, lfs_block_t block);
 int go_lfs_c_cb_sync(const struct lfs_config *c);
+int go_lfs_c_cb_lock(const struct lfs_config *c);
+int go_lfs_c_cb_unlock(const struct lfs_config *c);
 
 // Helper functions used to allocate new LFS objects, needed because TinyGo
 // does not support sizeof() yet
@@ -28,6 +32,15 @@ struct lfs_config* go_lfs_new_lfs_config(void);
 lfs_dir_t* go_lfs_new_lfs_dir(void);
 lfs_file_t* go_lfs_new_lfs_file(void);
 
+// Allocates a zeroed file config followed by room for attr_count custom
+// attributes, which cfg->attrs points to. Freed with a single call to free().
+struct lfs_file_config* go_lfs_new_lfs_file_config(lfs_size_t attr_count);
+
+// Helper function that traverses the blocks in use by the filesystem and sets
+// the corresponding bits in bitmap, which must hold block_count bits.
+int go_lfs_block_usage(lfs_t *lfs, uint8_t *bitmap);
+
 // Helper function to set the function pointers to the global callbacks on a
-// provided LFS config struct
-struct lfs_config* go_lfs_set_callbacks(struct lfs_config *cfg);
+// provided LFS config struct. The lock callbacks only call into Go if
+// threadsafe is set.
+struct lfs_config* go_lfs_set_callbacks(struct lfs_config *cfg, bool threadsafe);
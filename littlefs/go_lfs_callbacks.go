@@ -43,7 +43,10 @@ func go_lfs_block_device_erase(ctx unsafe.Pointer, block uint32) int {
 	if debug {
 		fmt.Printf("go_lfs_block_device_erase: %v, %v\n", ctx, block)
 	}
-	return go_lfs_block_errval("erase", restore(ctx).dev.EraseBlocks(int64(block), 1))
+	// a logical block may span several erase blocks of the device
+	fs := restore(ctx)
+	n := int64(fs.blockSize()) / fs.dev.EraseBlockSize()
+	return go_lfs_block_errval("erase", fs.dev.EraseBlocks(int64(block)*n, n))
 }
 
 //export go_lfs_block_device_sync
@@ -58,6 +61,18 @@ func go_lfs_block_device_sync(ctx unsafe.Pointer) int {
 	return errOK
 }
 
+//export go_lfs_lock
+func go_lfs_lock(ctx unsafe.Pointer) int {
+	restore(ctx).mu.Lock()
+	return errOK
+}
+
+//export go_lfs_unlock
+func go_lfs_unlock(ctx unsafe.Pointer) int {
+	restore(ctx).mu.Unlock()
+	return errOK
+}
+
 func go_lfs_block_errval(op string, err error) int {
 	if err != nil {
 		if debug {
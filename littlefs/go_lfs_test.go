@@ -1,12 +1,22 @@
 package littlefs
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"math/rand"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"tinygo.org/x/tinyfs"
+	"tinygo.org/x/tinyfs/internal/gopointer"
+	"tinygo.org/x/tinyfs/internal/testutil"
+	"tinygo.org/x/tinyfs/powerloss"
+	"tinygo.org/x/tinyfs/tinyfstest"
 )
 
 const (
@@ -16,10 +26,6 @@ const (
 )
 
 var defaultConfig = &Config{
-	//	ReadSize:      16,
-	//	ProgSize:      16,
-	//	BlockSize:     512,
-	//	BlockCount:    1024,
 	CacheSize:     128,
 	LookaheadSize: 128,
 	BlockCycles:   500,
@@ -28,7 +34,7 @@ var defaultConfig = &Config{
 var zeroBlock = make([]byte, testBlockSize)
 
 func TestFormat(t *testing.T) {
-	dev := tinyfs.NewMemoryDevice(testPageSize, testBlockSize, testBlockCount)
+	dev := newTestDevice()
 	fs := New(dev)
 	fs.Configure(defaultConfig)
 
@@ -48,6 +54,9 @@ func TestFormat(t *testing.T) {
 		if err := fs.Unmount(); err != nil {
 			t.Fatal(err)
 		}
+		if err := dev.EraseBlocks(0, 2); err != nil {
+			t.Fatal(err)
+		}
 		if _, err := dev.WriteAt(zeroBlock, 0); err != nil {
 			t.Fatal(err)
 		}
@@ -60,20 +69,20 @@ func TestFormat(t *testing.T) {
 				t.Log("expected error when mounting")
 				//t.Fail()
 			}
-		} else if err != errNoSpace {
+		} else if !errors.Is(err, errNoSpace) {
 			t.Logf("expected error to be ErrNoSpace; was %s", err)
 			t.Fail()
 		}
 	})
 
 	t.Run("InvalidMount", func(t *testing.T) {
-		dev := tinyfs.NewMemoryDevice(testPageSize, testBlockSize, testBlockCount)
+		dev := newTestDevice()
 		fs := New(dev)
 		fs.Configure(defaultConfig)
 		if err := fs.Mount(); err == nil {
 			t.Log("expected error when mounting")
 			t.Fail()
-		} else if err != errCorrupt {
+		} else if !errors.Is(err, errCorrupt) {
 			t.Logf("expected error to be ErrCorrupt; was %s", err)
 			t.Fail()
 		}
@@ -106,6 +115,78 @@ func TestFormat(t *testing.T) {
 	})
 }
 
+func TestConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config Config
+		err    string
+	}{
+		{"ZeroCacheSize", Config{LookaheadSize: 128, BlockCycles: 500},
+			"littlefs: cache size must not be 0"},
+		{"CacheNotMultipleOfRead", Config{ReadSize: 48, CacheSize: 128, LookaheadSize: 128, BlockCycles: 500},
+			"littlefs: cache size (128) must be a multiple of the read size (48)"},
+		{"BlockNotMultipleOfCache", Config{CacheSize: 192, LookaheadSize: 128, BlockCycles: 500},
+			"littlefs: block size (256) must be a multiple of the cache size (192)"},
+		{"BlockNotMultipleOfErase", Config{BlockSize: 384, CacheSize: 128, LookaheadSize: 128, BlockCycles: 500},
+			"littlefs: block size (384) must be a multiple of the erase block size of the device (256)"},
+		{"TooManyBlocks", Config{BlockCount: testBlockCount + 1, CacheSize: 128, LookaheadSize: 128, BlockCycles: 500},
+			"littlefs: 2049 blocks of 256 bytes do not fit on a device of 524288 bytes"},
+		{"ZeroBlockCycles", Config{CacheSize: 128, LookaheadSize: 128},
+			"littlefs: block cycles must not be 0; use -1 to disable wear-levelling"},
+		{"LookaheadNotMultipleOf8", Config{CacheSize: 128, LookaheadSize: 12, BlockCycles: 500},
+			"littlefs: lookahead size (12) must be a non-zero multiple of 8"},
+		{"NameMaxTooLarge", Config{CacheSize: 128, LookaheadSize: 128, BlockCycles: 500, NameMax: 256},
+			"littlefs: name max (256) must not exceed 255"},
+		{"MetadataMaxTooLarge", Config{CacheSize: 128, LookaheadSize: 128, BlockCycles: 500, MetadataMax: 512},
+			"littlefs: metadata max (512) must not exceed the block size (256)"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dev := newTestDevice()
+			lfs := New(dev).Configure(&tc.config)
+			for _, err := range []error{lfs.Format(), lfs.Mount()} {
+				if err == nil || errors.Unwrap(err).Error() != tc.err {
+					t.Errorf("expected error %q, was actually %v", tc.err, err)
+				}
+			}
+		})
+	}
+
+	for _, tc := range []struct {
+		name   string
+		config Config
+	}{
+		{"LargeLogicalBlocks", Config{BlockSize: 4 * testBlockSize, CacheSize: 128, LookaheadSize: 128, BlockCycles: 500}},
+		{"PartialDevice", Config{BlockCount: testBlockCount / 2, CacheSize: 128, LookaheadSize: 128, BlockCycles: 500}},
+		{"ReadSizeDiffers", Config{ReadSize: 16, ProgSize: 64, CacheSize: 128, LookaheadSize: 128, BlockCycles: -1}},
+		{"Limits", Config{CacheSize: 128, LookaheadSize: 128, BlockCycles: 500, NameMax: 32, FileMax: 65536, AttrMax: 64, MetadataMax: 128}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dev := newTestDevice()
+			tail := make([]byte, testBlockSize)
+			tailOffset := dev.Size() - testBlockSize
+			copy(tail, "untouched")
+			_, err := dev.WriteAt(tail, tailOffset)
+			check(t, err)
+
+			lfs := New(dev).Configure(&tc.config)
+			check(t, lfs.Format())
+			check(t, lfs.Mount())
+			defer lfs.Unmount()
+			writeFileTest(t, lfs, 8192, "avocado")
+			readFileTest(t, lfs, 8192, "avocado")
+
+			if tc.config.BlockCount != 0 {
+				buf := make([]byte, testBlockSize)
+				_, err := dev.ReadAt(buf, tailOffset)
+				check(t, err)
+				if string(buf[:9]) != "untouched" {
+					t.Fatal("filesystem wrote past the configured block count")
+				}
+			}
+		})
+	}
+}
+
 func TestFiles(t *testing.T) {
 	fs, _, unmount := createTestFS(t, defaultConfig)
 	defer unmount()
@@ -249,9 +330,696 @@ func TestDirectories(t *testing.T) {
 	})
 }
 
+func TestReaddir(t *testing.T) {
+	lfs, _, unmount := createTestFS(t, defaultConfig)
+	defer unmount()
+
+	check(t, lfs.Mkdir("/logs", 0777))
+	var expected []string
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("log%d.txt", i)
+		writeFileTest(t, lfs, i, "/logs/"+name)
+		expected = append(expected, name)
+	}
+	check(t, lfs.Mkdir("/logs/old", 0777))
+	expected = append(expected, "old")
+
+	f, err := lfs.Open("/logs")
+	check(t, err)
+	defer f.Close()
+	dir := f.(*File)
+
+	t.Run("SeekDir", func(t *testing.T) {
+		check(t, dir.RewindDir())
+		first, err := dir.Readdir(4)
+		check(t, err)
+		off, err := dir.TellDir()
+		check(t, err)
+		rest, err := dir.Readdir(-1)
+		check(t, err)
+		if len(first)+len(rest) != len(expected) {
+			t.Fatalf("expected %d entries, was actually %d", len(expected), len(first)+len(rest))
+		}
+
+		// the listing can be resumed from another handle
+		f, err := lfs.Open("/logs")
+		check(t, err)
+		defer f.Close()
+		check(t, f.(*File).SeekDir(off))
+		resumed, err := f.Readdir(0)
+		check(t, err)
+		if len(resumed) != len(rest) {
+			t.Fatalf("expected %d entries after SeekDir, was actually %d", len(rest), len(resumed))
+		}
+		for i := range rest {
+			expectString(t, rest[i].Name(), resumed[i].Name())
+		}
+	})
+
+	t.Run("ReadDir", func(t *testing.T) {
+		check(t, dir.RewindDir())
+		entries, err := dir.ReadDir(0)
+		check(t, err)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+			info, err := entry.Info()
+			check(t, err)
+			if entry.IsDir() != info.IsDir() || entry.Type() != info.Mode().Type() {
+				t.Errorf("%s: expected type %v, was actually %v", entry.Name(), info.Mode().Type(), entry.Type())
+			}
+			if !entry.IsDir() && info.ModTime().IsZero() {
+				t.Errorf("%s: expected a modification time", entry.Name())
+			}
+		}
+		testutil.ExpectNames(t, expected, names)
+		if _, err := dir.ReadDir(1); err != io.EOF {
+			t.Errorf("expected io.EOF, was actually %v", err)
+		}
+
+		// Info reflects the entry as of the call
+		check(t, lfs.Remove("/logs/log0.txt"))
+		for _, entry := range entries {
+			if entry.Name() == "log0.txt" {
+				if _, err := entry.Info(); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("expected %v, was actually %v", fs.ErrNotExist, err)
+				}
+			}
+		}
+	})
+
+	t.Run("NotDir", func(t *testing.T) {
+		f, err := lfs.Open("/logs/log1.txt")
+		check(t, err)
+		defer f.Close()
+		if _, err := f.Readdir(1); !errors.Is(err, errNotDir) {
+			t.Errorf("expected %v, was actually %v", errNotDir, err)
+		}
+		if err := f.(*File).RewindDir(); !errors.Is(err, errNotDir) {
+			t.Errorf("expected %v, was actually %v", errNotDir, err)
+		}
+	})
+}
+
+func TestModTime(t *testing.T) {
+	// the modification time is committed together with the data, so a power
+	// cut never separates them
+	old := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.Local)
+	for n := 1; ; n++ {
+		dev := tinyfs.NewFaultDevice(newTestDevice())
+		lfs := New(dev).Configure(defaultConfig)
+		check(t, lfs.Format())
+		check(t, lfs.Mount())
+		f, err := lfs.OpenFile("timed.txt", os.O_WRONLY|os.O_CREATE)
+		check(t, err)
+		check(t, f.Close())
+		check(t, lfs.Chtimes("timed.txt", old, old))
+
+		dev.CutPower(n, 0)
+		f, err = lfs.OpenFile("timed.txt", os.O_WRONLY)
+		if err == nil {
+			f.Write([]byte("tick"))
+			err = f.Close()
+		}
+		cut := dev.PowerLost()
+		dev.PowerOn()
+		lfs.Close()
+		if !cut {
+			check(t, err)
+		}
+
+		lfs = New(dev).Configure(defaultConfig)
+		check(t, lfs.Mount())
+		info, err := lfs.Stat("timed.txt")
+		check(t, err)
+		check(t, lfs.Close())
+		if written := info.Size() != 0; written == info.ModTime().Equal(old) {
+			t.Fatalf("power cut %d: size %d with modification time %v", n, info.Size(), info.ModTime())
+		}
+		if !cut {
+			break
+		}
+	}
+}
+
+func TestAttrs(t *testing.T) {
+	lfs, _, unmount := createTestFS(t, defaultConfig)
+	defer unmount()
+
+	f, err := lfs.OpenFile("tagged.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	check(t, err)
+	check(t, f.Close())
+
+	t.Run("SetGetRemove", func(t *testing.T) {
+		check(t, lfs.SetAttr("tagged.txt", 'c', []byte("crc32")))
+		buf := make([]byte, 8)
+		n, err := lfs.GetAttr("tagged.txt", 'c', buf)
+		check(t, err)
+		expectString(t, "crc32", string(buf[:n]))
+
+		// a short buffer receives a prefix, but the full size is reported
+		n, err = lfs.GetAttr("tagged.txt", 'c', buf[:3])
+		check(t, err)
+		if n != 5 || string(buf[:3]) != "crc" {
+			t.Errorf("expected 5 bytes starting with \"crc\", was actually %d bytes starting with %q", n, buf[:3])
+		}
+
+		check(t, lfs.RemoveAttr("tagged.txt", 'c'))
+		_, err = lfs.GetAttr("tagged.txt", 'c', buf)
+		if !errors.Is(err, errNoAttr) {
+			t.Errorf("expected %v, was actually %v", errNoAttr, err)
+		}
+	})
+
+	t.Run("Reserved", func(t *testing.T) {
+		err := lfs.SetAttr("tagged.txt", attrModTime, []byte("now"))
+		testutil.ExpectPathError(t, err, "setattr", "tagged.txt", fs.ErrInvalid)
+		err = lfs.RemoveAttr("tagged.txt", attrModTime)
+		testutil.ExpectPathError(t, err, "removeattr", "tagged.txt", fs.ErrInvalid)
+		_, err = lfs.OpenFileWithAttrs("tagged.txt", os.O_RDONLY, []Attr{{Type: attrModTime, Buffer: make([]byte, 8)}})
+		testutil.ExpectPathError(t, err, "open", "tagged.txt", fs.ErrInvalid)
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		_, err := lfs.GetAttr("missing", 'c', make([]byte, 8))
+		testutil.ExpectPathError(t, err, "getattr", "missing", fs.ErrNotExist)
+		err = lfs.SetAttr("missing", 'c', []byte("x"))
+		testutil.ExpectPathError(t, err, "setattr", "missing", fs.ErrNotExist)
+	})
+
+	t.Run("OpenFileWithAttrs", func(t *testing.T) {
+		version := []byte("v1")
+		f, err := lfs.OpenFileWithAttrs("versioned.txt", os.O_RDWR|os.O_CREATE|os.O_TRUNC, []Attr{{Type: 'v', Buffer: version}})
+		check(t, err)
+		_, err = f.Write([]byte("first"))
+		check(t, err)
+		check(t, f.Sync())
+		expectAttr(t, lfs, "versioned.txt", 'v', "v1")
+
+		// attributes are only written together with the file data
+		copy(version, "v2")
+		_, err = f.Write([]byte(" second"))
+		check(t, err)
+		expectAttr(t, lfs, "versioned.txt", 'v', "v1")
+		check(t, f.Sync())
+		expectAttr(t, lfs, "versioned.txt", 'v', "v2")
+		buf := make([]byte, 32)
+		n, err := f.ReadAt(buf, 0)
+		if err != io.EOF {
+			t.Fatalf("expected io.EOF, was actually %v", err)
+		}
+		expectString(t, "first second", string(buf[:n]))
+		check(t, f.Close())
+	})
+
+	t.Run("AttrOnlyChange", func(t *testing.T) {
+		mtime := time.Date(2021, time.March, 14, 15, 9, 26, 0, time.Local)
+		check(t, lfs.Chtimes("versioned.txt", mtime, mtime))
+		version := make([]byte, 2)
+		f, err := lfs.OpenFileWithAttrs("versioned.txt", os.O_RDWR, []Attr{{Type: 'v', Buffer: version}})
+		check(t, err)
+		expectString(t, "v2", string(version))
+		copy(version, "v3")
+		check(t, f.Close())
+		expectAttr(t, lfs, "versioned.txt", 'v', "v3")
+
+		// changing an attribute does not modify the file
+		info, err := lfs.Stat("versioned.txt")
+		check(t, err)
+		if !info.ModTime().Equal(mtime) {
+			t.Errorf("expected modification time %v, was actually %v", mtime, info.ModTime())
+		}
+	})
+
+	t.Run("ReadOnly", func(t *testing.T) {
+		version, missing := make([]byte, 4), []byte("keep")
+		f, err := lfs.OpenFileWithAttrs("versioned.txt", os.O_RDONLY, []Attr{
+			{Type: 'v', Buffer: version},
+			{Type: 'm', Buffer: missing},
+		})
+		check(t, err)
+		// shorter attributes are padded with zeros, missing ones are untouched
+		expectString(t, "v3\x00\x00", string(version))
+		expectString(t, "keep", string(missing))
+		copy(version, "v4")
+		check(t, f.Close())
+		expectAttr(t, lfs, "versioned.txt", 'v', "v3")
+		_, err = lfs.GetAttr("versioned.txt", 'm', make([]byte, 4))
+		if !errors.Is(err, errNoAttr) {
+			t.Errorf("expected %v, was actually %v", errNoAttr, err)
+		}
+	})
+
+	t.Run("Remount", func(t *testing.T) {
+		check(t, lfs.Unmount())
+		check(t, lfs.Mount())
+		expectAttr(t, lfs, "versioned.txt", 'v', "v3")
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		config := *defaultConfig
+		config.AttrMax = 4
+		small, _, unmount := createTestFS(t, &config)
+		defer unmount()
+		_, err := small.OpenFileWithAttrs("big", os.O_WRONLY|os.O_CREATE, []Attr{{Type: 'b', Buffer: make([]byte, 5)}})
+		testutil.ExpectPathError(t, err, "open", "big", errNoSpace)
+		err = small.SetAttr("/", 'b', make([]byte, 5))
+		testutil.ExpectPathError(t, err, "setattr", "/", errNoSpace)
+	})
+}
+
+func expectAttr(t *testing.T, lfs *LFS, path string, typ uint8, expected string) {
+	t.Helper()
+	buf := make([]byte, 16)
+	n, err := lfs.GetAttr(path, typ, buf)
+	check(t, err)
+	expectString(t, expected, string(buf[:n]))
+}
+
+func TestFSStat(t *testing.T) {
+	config := *defaultConfig
+	config.NameMax, config.FileMax, config.AttrMax = 32, 65536, 64
+	lfs, _, unmount := createTestFS(t, &config)
+	defer unmount()
+
+	info, err := lfs.FSStat()
+	check(t, err)
+	expected := FSInfo{
+		DiskVersion: 0x00020001,
+		BlockSize:   testBlockSize,
+		BlockCount:  testBlockCount,
+		NameMax:     32,
+		FileMax:     65536,
+		AttrMax:     64,
+	}
+	if info != expected {
+		t.Fatalf("expected %+v, was actually %+v", expected, info)
+	}
+}
+
+func TestStatFS(t *testing.T) {
+	lfs, _, unmount := createTestFS(t, defaultConfig)
+	defer unmount()
+
+	usage, err := lfs.BlockUsage()
+	check(t, err)
+	// a new filesystem only uses the superblock pair
+	if usage.Count() != 2 || !usage.InUse(0) || !usage.InUse(1) {
+		t.Fatalf("expected blocks 0 and 1 to be in use, was actually %d blocks: %x", usage.Count(), []byte(usage))
+	}
+
+	writeFileTest(t, lfs, 8192, "avocado")
+	usage, err = lfs.BlockUsage()
+	check(t, err)
+	// 8192 bytes do not fit in 32 blocks of 256 bytes with their CTZ pointers
+	if usage.Count() <= 2+8192/testBlockSize {
+		t.Fatalf("expected more than %d blocks in use, was actually %d", 2+8192/testBlockSize, usage.Count())
+	}
+	if usage.InUse(testBlockCount) {
+		t.Fatal("expected blocks past the end not to be in use")
+	}
+
+	stat, err := lfs.StatFS()
+	check(t, err)
+	expected := tinyfs.FSStat{
+		BlockSize:  testBlockSize,
+		TotalBytes: testBlockSize * testBlockCount,
+		FreeBytes:  int64(testBlockCount-usage.Count()) * testBlockSize,
+		UsedBytes:  int64(usage.Count()) * testBlockSize,
+		NameMax:    255,
+	}
+	if stat != expected {
+		t.Fatalf("expected %+v, was actually %+v", expected, stat)
+	}
+}
+
+func TestGCAndMakeConsistent(t *testing.T) {
+	lfs, _, unmount := createTestFS(t, defaultConfig)
+	defer unmount()
+
+	writeFileTest(t, lfs, 4096, "avocado")
+	check(t, lfs.Remove("avocado"))
+	check(t, lfs.GC())
+	check(t, lfs.MakeConsistent())
+	writeFileTest(t, lfs, 4096, "burrito")
+	readFileTest(t, lfs, 4096, "burrito")
+}
+
+func TestGrow(t *testing.T) {
+	const smallBlockCount = testBlockCount / 8
+	dev := newTestDevice()
+	config := *defaultConfig
+	config.BlockCount = smallBlockCount
+	lfs := New(dev).Configure(&config)
+	check(t, lfs.Format())
+	check(t, lfs.Mount())
+
+	// fill the small filesystem until it runs out of space
+	var names []string
+	for i := 0; ; i++ {
+		name := fmt.Sprintf("file%d", i)
+		if err := writeGrowFile(lfs, name, i); err != nil {
+			if !errors.Is(err, errNoSpace) {
+				t.Fatal(err)
+			}
+			break
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		t.Fatal("expected to write at least one file")
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		err := lfs.Grow(smallBlockCount - 1)
+		testutil.ExpectPathError(t, err, "grow", "/", fs.ErrInvalid)
+		err = lfs.Grow(testBlockCount + 1)
+		testutil.ExpectPathError(t, err, "grow", "/", errNoSpace)
+	})
+
+	check(t, lfs.Grow(testBlockCount))
+	info, err := lfs.FSStat()
+	check(t, err)
+	if info.BlockCount != testBlockCount {
+		t.Fatalf("expected %d blocks, was actually %d", testBlockCount, info.BlockCount)
+	}
+	check(t, writeGrowFile(lfs, "grown", len(names)))
+	names = append(names, "grown")
+
+	check(t, lfs.Unmount())
+	check(t, lfs.Mount())
+	check(t, lfs.Unmount())
+
+	// the superblock now records the full device
+	lfs = New(dev).Configure(defaultConfig)
+	check(t, lfs.Mount())
+	defer lfs.Unmount()
+	for i, name := range names {
+		f, err := lfs.Open(name)
+		check(t, err)
+		buf := make([]byte, 2048)
+		n, err := io.ReadFull(f, buf)
+		check(t, err)
+		check(t, f.Close())
+		if !bytes.Equal(buf[:n], growFileContents(i)) {
+			t.Fatalf("%s: contents did not survive growing the filesystem", name)
+		}
+	}
+}
+
+func growFileContents(i int) []byte {
+	return bytes.Repeat([]byte{byte(i)}, 2048)
+}
+
+func writeGrowFile(lfs *LFS, name string, i int) error {
+	f, err := lfs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(growFileContents(i)); err != nil {
+		f.Close()
+		lfs.Remove(name)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		lfs.Remove(name)
+		return err
+	}
+	return nil
+}
+
+func TestLifecycle(t *testing.T) {
+	dev := newTestDevice()
+	saved := gopointer.Len()
+
+	t.Run("Reconfigure", func(t *testing.T) {
+		lfs := New(dev)
+		for i := 0; i < 10; i++ {
+			lfs.Configure(defaultConfig)
+			check(t, lfs.Format())
+			check(t, lfs.Mount())
+			if n := gopointer.Len() - saved; n != 1 {
+				t.Fatalf("expected 1 outstanding gopointer, was actually %d", n)
+			}
+		}
+		check(t, lfs.Close())
+		if n := gopointer.Len() - saved; n != 0 {
+			t.Fatalf("expected no outstanding gopointers, was actually %d", n)
+		}
+		err := lfs.Mount()
+		testutil.ExpectPathError(t, err, "mount", "/", errNotConfigured)
+	})
+
+	t.Run("OpenHandles", func(t *testing.T) {
+		lfs := New(dev).Configure(defaultConfig)
+		check(t, lfs.Format())
+		check(t, lfs.Mount())
+		check(t, lfs.Mkdir("dir", 0777))
+		dir, err := lfs.Open("dir")
+		check(t, err)
+		f, err := lfs.OpenFile("pending.txt", os.O_WRONLY|os.O_CREATE)
+		check(t, err)
+		_, err = f.Write([]byte("pending"))
+		check(t, err)
+
+		// closing the filesystem closes the files, which writes them out
+		check(t, lfs.Close())
+		if n := gopointer.Len() - saved; n != 0 {
+			t.Fatalf("expected no outstanding gopointers, was actually %d", n)
+		}
+		check(t, f.Close())
+		check(t, dir.Close())
+
+		lfs = New(dev).Configure(defaultConfig)
+		defer lfs.Close()
+		check(t, lfs.Mount())
+		f, err = lfs.Open("pending.txt")
+		check(t, err)
+		defer f.Close()
+		buf := make([]byte, 16)
+		n, err := f.Read(buf)
+		check(t, err)
+		expectString(t, "pending", string(buf[:n]))
+	})
+}
+
+func TestNotMounted(t *testing.T) {
+	lfs, _, unmount := createTestFS(t, defaultConfig)
+	defer unmount()
+	check(t, lfs.Mkdir("dir", 0777))
+
+	for _, tc := range []struct {
+		name  string
+		leave func() error
+	}{
+		{"Unmount", lfs.Unmount},
+		{"Close", func() error {
+			check(t, lfs.Mount())
+			return lfs.Close()
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			check(t, tc.leave())
+
+			// littlefs has freed its state, so none of these may reach it
+			buf := make([]byte, 4)
+			for _, op := range []struct {
+				op   string
+				path string
+				err  error
+			}{
+				{"open", "new.txt", testutil.Second(lfs.OpenFileWithAttrs("new.txt", os.O_RDONLY, []Attr{{Type: 'c', Buffer: buf}}))},
+				{"getattr", "dir", testutil.Second(lfs.GetAttr("dir", 'c', buf))},
+				{"setattr", "dir", lfs.SetAttr("dir", 'c', buf)},
+				{"removeattr", "dir", lfs.RemoveAttr("dir", 'c')},
+				{"size", "/", testutil.Second(lfs.Size())},
+				{"traverse", "/", testutil.Second(lfs.BlockUsage())},
+				{"statfs", "/", testutil.Second(lfs.FSStat())},
+				{"gc", "/", lfs.GC()},
+				{"mkconsistent", "/", lfs.MakeConsistent()},
+				{"grow", "/", lfs.Grow(testBlockCount)},
+			} {
+				testutil.ExpectPathError(t, op.err, op.op, op.path, tinyfs.ErrNotMounted)
+			}
+		})
+	}
+}
+
+func TestMaxOpenFiles(t *testing.T) {
+	config := *defaultConfig
+	config.MaxOpenFiles = 2
+	tinyfstest.TestMaxOpenFiles(t, func() tinyfs.Filesystem {
+		return newFormattedFS(t, &config)
+	}, 2)
+}
+
+func TestErrors(t *testing.T) {
+	for _, tc := range []struct {
+		err    Error
+		target error
+	}{
+		{errNoEntry, fs.ErrNotExist},
+		{errEntryExists, fs.ErrExist},
+		{errDirNotEmpty, fs.ErrExist},
+		{errInvalidParam, fs.ErrInvalid},
+		{errBadFileNum, fs.ErrClosed},
+		{errNoSpace, tinyfs.ErrNoSpace},
+	} {
+		if !errors.Is(tc.err, tc.target) {
+			t.Errorf("expected %v to be %v", tc.err, tc.target)
+		}
+	}
+	if errors.Is(errCorrupt, fs.ErrNotExist) {
+		t.Errorf("expected %v not to be %v", errCorrupt, fs.ErrNotExist)
+	}
+}
+
+func TestBlockDeviceErrors(t *testing.T) {
+	tinyfstest.TestBlockDeviceErrors(t, func() tinyfs.BlockDevice {
+		return newTestDevice()
+	}, func(dev tinyfs.BlockDevice) tinyfs.Filesystem {
+		return New(dev).Configure(defaultConfig)
+	})
+
+	// littlefs reports the failures of the device as I/O errors and keeps the
+	// last committed contents
+	dev := tinyfs.NewFaultDevice(newTestDevice())
+	lfs := New(dev).Configure(defaultConfig)
+	check(t, lfs.Format())
+	check(t, lfs.Mount())
+	defer func() {
+		dev.PowerOn()
+		check(t, lfs.Close())
+	}()
+	writeFile := func(name, contents string) error {
+		f, err := lfs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write([]byte(contents))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+	readFile := func(name string) string {
+		t.Helper()
+		f, err := lfs.Open(name)
+		check(t, err)
+		defer f.Close()
+		data, err := io.ReadAll(f)
+		check(t, err)
+		return string(data)
+	}
+	expectIO := func(err error) {
+		t.Helper()
+		if !errors.Is(err, errIO) {
+			t.Errorf("expected %v, was actually %v", errIO, err)
+		}
+	}
+	contents := strings.Repeat("old contents ", 100)
+	check(t, writeFile("/file.txt", contents))
+
+	t.Run("Read", func(t *testing.T) {
+		check(t, lfs.Unmount())
+		dev.FailRead(1)
+		err := lfs.Mount()
+		testutil.ExpectPathError(t, err, "mount", "/", errIO)
+		check(t, lfs.Mount())
+		expectString(t, contents, readFile("/file.txt"))
+	})
+
+	t.Run("Program", func(t *testing.T) {
+		dev.FailProgram(1)
+		expectIO(writeFile("/file.txt", strings.Repeat("new contents ", 100)))
+		check(t, lfs.Mount())
+		expectString(t, contents, readFile("/file.txt"))
+	})
+
+	t.Run("Erase", func(t *testing.T) {
+		dev.FailErase(1)
+		var err error
+		for i := 0; i < 100 && err == nil; i++ {
+			err = writeFile(fmt.Sprintf("/file%d.txt", i), contents)
+		}
+		expectIO(err)
+		check(t, lfs.Mount())
+		expectString(t, contents, readFile("/file.txt"))
+	})
+
+	t.Run("PowerCut", func(t *testing.T) {
+		dev.CutPower(1, testPageSize/2)
+		expectIO(writeFile("/file.txt", strings.Repeat("new contents ", 100)))
+		if !dev.PowerLost() {
+			t.Fatal("expected the power to be cut")
+		}
+		dev.PowerOn()
+		// the torn page is never committed
+		check(t, lfs.Mount())
+		expectString(t, contents, readFile("/file.txt"))
+		check(t, writeFile("/file.txt", "after the power cut"))
+		expectString(t, "after the power cut", readFile("/file.txt"))
+	})
+
+	t.Run("WearOut", func(t *testing.T) {
+		dev.WearOut(1)
+		var err error
+		for i := 0; i < 100 && err == nil; i++ {
+			err = writeFile(fmt.Sprintf("/worn%d.txt", i), contents)
+		}
+		expectIO(err)
+		dev.WearOut(0)
+	})
+}
+
+func TestPowerLoss(t *testing.T) {
+	newDevice := func() tinyfs.BlockDevice {
+		return tinyfs.NewMemoryDevice(testPageSize, testBlockSize, 64).SetStrict(tinyfs.StrictNAND)
+	}
+	newFS := func(dev tinyfs.BlockDevice) tinyfs.Filesystem {
+		return New(dev).Configure(defaultConfig)
+	}
+	large := strings.Repeat("larger than a block ", 20)
+	powerloss.Run(t, newDevice, newFS, []powerloss.Op{
+		powerloss.Create("/empty.txt"),
+		powerloss.Create("/small.txt"),
+		powerloss.Write("/small.txt", "inline"),
+		powerloss.Create("/large.txt"),
+		powerloss.Write("/large.txt", large),
+		powerloss.Write("/large.txt", strings.ToUpper(large)),
+		powerloss.Write("/small.txt", large),
+		powerloss.Rename("/small.txt", "/renamed.txt"),
+		powerloss.Rename("/renamed.txt", "/large.txt"),
+		powerloss.Truncate("/large.txt", 100),
+		powerloss.Truncate("/empty.txt", 1000),
+		powerloss.Remove("/empty.txt"),
+		powerloss.Remove("/large.txt"),
+	})
+}
+
+func TestConformance(t *testing.T) {
+	tinyfstest.TestFilesystem(t, func() tinyfs.Filesystem {
+		return newFormattedFS(t, defaultConfig)
+	})
+}
+
+// newTestDevice returns a memory device that enforces the rules of NAND
+// flash, which littlefs is designed to follow.
+func newTestDevice() *tinyfs.MemBlockDevice {
+	return tinyfs.NewMemoryDevice(testPageSize, testBlockSize, testBlockCount).SetStrict(tinyfs.StrictNAND)
+}
+
+// newFormattedFS returns a filesystem on a new test device that is formatted
+// but not mounted.
+func newFormattedFS(t *testing.T, config *Config) *LFS {
+	lfs := New(newTestDevice()).Configure(config)
+	check(t, lfs.Format())
+	return lfs
+}
+
 func createTestFS(t *testing.T, config *Config) (*LFS, tinyfs.BlockDevice, func()) {
 	// create/format/mount the filesystem
-	bd := tinyfs.NewMemoryDevice(testPageSize, testBlockSize, testBlockCount)
+	bd := newTestDevice()
 	fs := New(bd).Configure(config)
 	if err := fs.Format(); err != nil {
 		t.Error("Could not format", err)
@@ -260,7 +1028,7 @@ func createTestFS(t *testing.T, config *Config) (*LFS, tinyfs.BlockDevice, func(
 		t.Error("Could not mount", err)
 	}
 	return fs, bd, func() {
-		if err := fs.Unmount(); err != nil {
+		if err := fs.Close(); err != nil {
 			t.Error("Could not ummount", err)
 		}
 	}
@@ -330,6 +1098,12 @@ func readFileTest(t *testing.T, lfs *LFS, size int, name string) {
 	}
 }
 
+func expectString(t *testing.T, expected string, actual string) {
+	if expected != actual {
+		t.Fatalf("expected \"%s\", was actually \"%s\"", expected, actual)
+	}
+}
+
 func check(t *testing.T, err error) {
 	if err != nil {
 		t.Fatal(err)
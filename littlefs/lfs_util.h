@@ -14,6 +14,10 @@
 #define LFS_NO_ERROR 1
 #define LFS_NO_WARN 1
 
+// The lock and unlock callbacks are always present; the Go bindings make them
+// no-ops unless the filesystem is configured to be thread-safe.
+#define LFS_THREADSAFE 1
+
 // Users can override lfs_util.h with their own configuration by defining
 // LFS_CONFIG as a header file to include (-DLFS_CONFIG=lfs_config.h).
 //
@@ -0,0 +1,113 @@
+package tinyfs_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+
+	"tinygo.org/x/tinyfs"
+)
+
+func TestLocking(t *testing.T) {
+	for name, newFS := range filesystems() {
+		t.Run(name, func(t *testing.T) {
+			testLocking(t, mountTestFS(t, newFS))
+		})
+	}
+}
+
+func testLocking(t *testing.T, filesystem tinyfs.Filesystem) {
+	open := func(name string) tinyfs.Locker {
+		t.Helper()
+		f, err := filesystem.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		locker, ok := f.(tinyfs.Locker)
+		if !ok {
+			t.Fatalf("%T does not implement tinyfs.Locker", f)
+		}
+		return locker
+	}
+	expectLocked := func(err error) {
+		t.Helper()
+		if !errors.Is(err, tinyfs.ErrLocked) {
+			t.Errorf("expected %v, was actually %v", tinyfs.ErrLocked, err)
+		}
+	}
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"/lock.txt", "/other.txt"} {
+		f, err := filesystem.OpenFile(name, os.O_WRONLY|os.O_CREATE)
+		check(err)
+		check(f.Close())
+	}
+
+	a := open("/lock.txt")
+	b := open("/lock.txt")
+	c := open("lock.txt")
+
+	check(a.TryLock(tinyfs.LockExclusive))
+	expectLocked(b.TryLock(tinyfs.LockShared))
+	expectLocked(c.TryLock(tinyfs.LockExclusive))
+
+	// shared locks coexist, but exclude exclusive ones
+	check(a.TryLock(tinyfs.LockShared))
+	check(b.TryLock(tinyfs.LockShared))
+	expectLocked(c.TryLock(tinyfs.LockExclusive))
+	expectLocked(a.TryLock(tinyfs.LockExclusive))
+
+	// a failed conversion keeps the lock that was held
+	check(b.Unlock())
+	expectLocked(c.TryLock(tinyfs.LockExclusive))
+
+	// closing a file releases its lock
+	check(a.(tinyfs.File).Close())
+	check(c.TryLock(tinyfs.LockExclusive))
+	check(c.Unlock())
+	check(c.Unlock())
+
+	// other files are not affected
+	check(open("/other.txt").TryLock(tinyfs.LockExclusive))
+
+	t.Run("Wait", func(t *testing.T) {
+		check(b.Lock(tinyfs.LockShared))
+		done := make(chan error, 1)
+		go func() {
+			done <- c.Lock(tinyfs.LockExclusive)
+		}()
+		select {
+		case err := <-done:
+			t.Fatalf("Lock did not wait for the shared lock to be released: %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+		check(b.Unlock())
+		check(<-done)
+		expectLocked(b.TryLock(tinyfs.LockShared))
+		check(c.Unlock())
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		if err := b.Lock(tinyfs.LockType(-1)); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("expected %v, was actually %v", fs.ErrInvalid, err)
+		}
+		check(b.(tinyfs.File).Close())
+		for _, err := range []error{
+			b.Lock(tinyfs.LockShared),
+			b.TryLock(tinyfs.LockExclusive),
+			b.Unlock(),
+		} {
+			if !errors.Is(err, fs.ErrClosed) {
+				t.Errorf("expected %v, was actually %v", fs.ErrClosed, err)
+			}
+		}
+	})
+}
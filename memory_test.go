@@ -0,0 +1,76 @@
+package tinyfs_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"tinygo.org/x/tinyfs"
+)
+
+func TestMemBlockDevice(t *testing.T) {
+	page := func(b byte) []byte {
+		return bytes.Repeat([]byte{b}, 16)
+	}
+	expectErr := func(err, target error) {
+		t.Helper()
+		if !errors.Is(err, target) {
+			t.Errorf("expected %v, was actually %v", target, err)
+		}
+	}
+	write := func(dev *tinyfs.MemBlockDevice, buf []byte, off int64) error {
+		_, err := dev.WriteAt(buf, off)
+		return err
+	}
+
+	t.Run("Off", func(t *testing.T) {
+		dev := tinyfs.NewMemoryDevice(16, 64, 4)
+		expectErr(write(dev, page(0x00), 0), nil)
+		expectErr(write(dev, []byte{0xff}, 3), nil)
+		buf := make([]byte, 4)
+		_, err := dev.ReadAt(buf, 0)
+		expectErr(err, nil)
+		if !bytes.Equal(buf, []byte{0, 0, 0, 0xff}) {
+			t.Errorf("expected the write to overwrite memory, was actually %x", buf)
+		}
+	})
+
+	t.Run("NOR", func(t *testing.T) {
+		dev := tinyfs.NewMemoryDevice(16, 64, 4).SetStrict(tinyfs.StrictNOR)
+		expectErr(write(dev, page(0xf0), 16), nil)
+		expectErr(write(dev, page(0x30), 16), nil)
+		expectErr(write(dev, page(0x0f), 16), tinyfs.ErrNotErased)
+		expectErr(write(dev, page(0x00)[:8], 16), tinyfs.ErrUnaligned)
+		expectErr(write(dev, page(0x00), 8), tinyfs.ErrUnaligned)
+		expectErr(dev.EraseBlocks(0, 1), nil)
+		expectErr(write(dev, page(0x0f), 16), nil)
+	})
+
+	t.Run("NAND", func(t *testing.T) {
+		dev := tinyfs.NewMemoryDevice(16, 64, 4).SetStrict(tinyfs.StrictNAND)
+		expectErr(write(dev, page(0xf0), 16), nil)
+		expectErr(write(dev, page(0x30), 16), tinyfs.ErrNotErased)
+		expectErr(write(dev, append(page(0xff), page(0x00)...), 0), tinyfs.ErrNotErased)
+		expectErr(write(dev, page(0x00), 32), nil)
+		expectErr(dev.EraseBlocks(0, 1), nil)
+		expectErr(write(dev, page(0x30), 16), nil)
+	})
+
+	t.Run("OutOfRange", func(t *testing.T) {
+		dev := tinyfs.NewMemoryDevice(16, 64, 4)
+		buf := make([]byte, 32)
+		n, err := dev.ReadAt(buf, dev.Size()-16)
+		expectErr(err, tinyfs.ErrOutOfRange)
+		if n != 16 {
+			t.Errorf("expected to read the last 16 bytes, was actually %d", n)
+		}
+		_, err = dev.ReadAt(buf, -1)
+		expectErr(err, tinyfs.ErrOutOfRange)
+		_, err = dev.ReadAt(buf, dev.Size()+1)
+		expectErr(err, tinyfs.ErrOutOfRange)
+		expectErr(write(dev, buf, dev.Size()-16), tinyfs.ErrOutOfRange)
+		expectErr(write(dev, buf, -16), tinyfs.ErrOutOfRange)
+		expectErr(dev.EraseBlocks(3, 2), tinyfs.ErrOutOfRange)
+		expectErr(dev.EraseBlocks(-1, 1), tinyfs.ErrOutOfRange)
+	})
+}
@@ -0,0 +1,343 @@
+// Package powerloss checks that a filesystem survives losing power at any
+// point of a workload, as littlefs is designed to.
+//
+// Run performs a workload once while recording every program and erase of
+// the block device. Then, for every point between two of those operations,
+// it replays the operations up to that point onto a fresh device, which
+// leaves it as a power cut there would, mounts the filesystem on it and
+// checks that every file has either the contents from before the operation
+// of the workload that was interrupted, or from after it.
+//
+// Workloads are built from the operations of this package, such as Write and
+// Rename, or from custom ones for the operations of an application:
+//
+//	powerloss.Run(t, newDevice, newFS, []powerloss.Op{
+//		powerloss.Create("/config.json"),
+//		powerloss.Write("/config.json", `{"volume": 3}`),
+//		{
+//			Name:  "save config",
+//			Run:   saveConfig,
+//			Apply: func(files map[string]string) { files["/config.json"] = `{"volume": 4}` },
+//		},
+//	})
+package powerloss
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"tinygo.org/x/tinyfs"
+)
+
+// Op is an operation of a workload.
+type Op struct {
+	// Name describes the operation in test failures.
+	Name string
+
+	// Run performs the operation on the filesystem. The operation must
+	// have reached storage when it returns, so files have to be closed or
+	// synced.
+	Run func(filesystem tinyfs.Filesystem) error
+
+	// Apply updates the expected contents of the files, keyed by absolute
+	// path, to those after the operation.
+	Apply func(files map[string]string)
+}
+
+// Create creates an empty file, which must not exist yet.
+func Create(name string) Op {
+	return Op{
+		Name: "create " + name,
+		Run: func(filesystem tinyfs.Filesystem) error {
+			f, err := filesystem.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+			if err != nil {
+				return err
+			}
+			return f.Close()
+		},
+		Apply: func(files map[string]string) {
+			files[name] = ""
+		},
+	}
+}
+
+// Write replaces the contents of an existing file. Files have to be created
+// with Create first, because creating a file and writing to it are separate
+// steps on disk, so that a power cut in between leaves an empty file.
+func Write(name string, data string) Op {
+	return Op{
+		Name: fmt.Sprintf("write %d bytes to %s", len(data), name),
+		Run: func(filesystem tinyfs.Filesystem) error {
+			f, err := filesystem.OpenFile(name, os.O_WRONLY|os.O_TRUNC)
+			if err != nil {
+				return err
+			}
+			if _, err := f.Write([]byte(data)); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		},
+		Apply: func(files map[string]string) {
+			files[name] = data
+		},
+	}
+}
+
+// Rename renames a file, replacing newName if it exists.
+func Rename(oldName, newName string) Op {
+	return Op{
+		Name: "rename " + oldName + " to " + newName,
+		Run: func(filesystem tinyfs.Filesystem) error {
+			return filesystem.Rename(oldName, newName)
+		},
+		Apply: func(files map[string]string) {
+			files[newName] = files[oldName]
+			delete(files, oldName)
+		},
+	}
+}
+
+// Remove removes a file.
+func Remove(name string) Op {
+	return Op{
+		Name: "remove " + name,
+		Run: func(filesystem tinyfs.Filesystem) error {
+			return filesystem.Remove(name)
+		},
+		Apply: func(files map[string]string) {
+			delete(files, name)
+		},
+	}
+}
+
+// Truncate changes the size of a file, filling it up with zeros if it grows.
+func Truncate(name string, size int64) Op {
+	return Op{
+		Name: fmt.Sprintf("truncate %s to %d bytes", name, size),
+		Run: func(filesystem tinyfs.Filesystem) error {
+			f, err := filesystem.OpenFile(name, os.O_WRONLY)
+			if err != nil {
+				return err
+			}
+			if err := f.Truncate(size); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		},
+		Apply: func(files map[string]string) {
+			data := files[name]
+			if int64(len(data)) > size {
+				files[name] = data[:size]
+			} else {
+				files[name] = data + strings.Repeat("\x00", int(size)-len(data))
+			}
+		},
+	}
+}
+
+// Run runs workload on a filesystem that newFS creates on a device from
+// newDevice, and checks the filesystem at every point where the power could
+// be cut, as described in the package documentation. newDevice must return
+// a device in the same state each time, such as a new MemBlockDevice. The
+// filesystem is formatted first, but power cuts are only simulated after it
+// has been mounted.
+func Run(t testing.TB, newDevice func() tinyfs.BlockDevice, newFS func(tinyfs.BlockDevice) tinyfs.Filesystem, workload []Op) {
+	t.Helper()
+	log := &recorder{BlockDevice: newDevice(), op: -1}
+	filesystem := newFS(log)
+	if err := filesystem.Format(); err != nil {
+		t.Errorf("format: %v", err)
+		return
+	}
+	if err := filesystem.Mount(); err != nil {
+		t.Errorf("mount: %v", err)
+		return
+	}
+	start := len(log.events)
+	for i, op := range workload {
+		log.op = i
+		if err := op.Run(filesystem); err != nil {
+			t.Errorf("%s: %v", op.Name, err)
+			filesystem.Unmount()
+			return
+		}
+	}
+	log.op = len(workload)
+	if err := filesystem.Unmount(); err != nil {
+		t.Errorf("unmount: %v", err)
+		return
+	}
+
+	// expected[i] holds the files before operation i
+	expected := []map[string]string{{}}
+	for _, op := range workload {
+		files := make(map[string]string)
+		for name, data := range expected[len(expected)-1] {
+			files[name] = data
+		}
+		op.Apply(files)
+		expected = append(expected, files)
+	}
+
+	for cut := start; cut <= len(log.events); cut++ {
+		// the operation that is cut short, or the last one if none is
+		op := len(workload) - 1
+		if cut < len(log.events) && log.events[cut].op < len(workload) {
+			op = log.events[cut].op
+		}
+		if op < 0 {
+			continue
+		}
+		if err := checkCut(newDevice(), newFS, log.events[:cut], expected[op], expected[op+1]); err != nil {
+			t.Errorf("power cut after %d of %d programs and erases, during %s: %v",
+				cut, len(log.events), workload[op].Name, err)
+			return
+		}
+	}
+	t.Logf("checked %d power cuts", len(log.events)-start+1)
+}
+
+// checkCut replays events onto dev and checks that the filesystem on it can
+// be mounted and that all files are as in before or as in after.
+func checkCut(dev tinyfs.BlockDevice, newFS func(tinyfs.BlockDevice) tinyfs.Filesystem, events []event, before, after map[string]string) error {
+	for _, ev := range events {
+		if err := ev.replay(dev); err != nil {
+			return fmt.Errorf("replay: %w", err)
+		}
+	}
+	filesystem := newFS(dev)
+	if err := filesystem.Mount(); err != nil {
+		return err
+	}
+	defer filesystem.Unmount()
+	actual := make(map[string]string)
+	if err := readFiles(filesystem, "/", actual); err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	for _, files := range []map[string]string{before, after, actual} {
+		for name := range files {
+			names[name] = true
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		if !sameFile(actual, before, name) && !sameFile(actual, after, name) {
+			return fmt.Errorf("%s is %s, expected %s or %s", name,
+				describe(actual, name), describe(before, name), describe(after, name))
+		}
+	}
+	return nil
+}
+
+// readFiles reads the contents of all files in dir and its subdirectories
+// into files.
+func readFiles(filesystem tinyfs.Filesystem, dir string, files map[string]string) error {
+	d, err := filesystem.Open(dir)
+	if err != nil {
+		return err
+	}
+	infos, err := d.Readdir(0)
+	d.Close()
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		name := path.Join(dir, info.Name())
+		if info.IsDir() {
+			if err := readFiles(filesystem, name, files); err != nil {
+				return err
+			}
+			continue
+		}
+		f, err := filesystem.Open(name)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		files[name] = string(data)
+	}
+	return nil
+}
+
+// sameFile reports whether name is the same in both sets of files, which
+// includes not existing in either.
+func sameFile(a, b map[string]string, name string) bool {
+	dataA, okA := a[name]
+	dataB, okB := b[name]
+	return okA == okB && dataA == dataB
+}
+
+func describe(files map[string]string, name string) string {
+	data, ok := files[name]
+	switch {
+	case !ok:
+		return "missing"
+	case len(data) > 16:
+		return fmt.Sprintf("%q... (%d bytes)", data[:16], len(data))
+	default:
+		return fmt.Sprintf("%q", data)
+	}
+}
+
+// event is a program or erase recorded by recorder.
+type event struct {
+	op    int
+	off   int64
+	data  []byte
+	erase int64
+}
+
+func (ev event) replay(dev tinyfs.BlockDevice) error {
+	if ev.data == nil {
+		return dev.EraseBlocks(ev.off, ev.erase)
+	}
+	_, err := dev.WriteAt(ev.data, ev.off)
+	return err
+}
+
+// recorder is a BlockDevice that records the programs and erases of the
+// operation op of the workload.
+type recorder struct {
+	tinyfs.BlockDevice
+	op     int
+	events []event
+}
+
+func (r *recorder) WriteAt(buf []byte, off int64) (int, error) {
+	n, err := r.BlockDevice.WriteAt(buf, off)
+	if err == nil {
+		r.events = append(r.events, event{op: r.op, off: off, data: append([]byte{}, buf...)})
+	}
+	return n, err
+}
+
+func (r *recorder) Sync() error {
+	if syncer, ok := r.BlockDevice.(tinyfs.Syncer); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
+
+func (r *recorder) EraseBlocks(start, len int64) error {
+	err := r.BlockDevice.EraseBlocks(start, len)
+	if err == nil {
+		r.events = append(r.events, event{op: r.op, off: start, erase: len})
+	}
+	return err
+}
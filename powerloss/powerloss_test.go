@@ -0,0 +1,74 @@
+package powerloss_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"tinygo.org/x/tinyfs"
+	"tinygo.org/x/tinyfs/fatfs"
+	"tinygo.org/x/tinyfs/littlefs"
+	"tinygo.org/x/tinyfs/powerloss"
+)
+
+// errorRecorder records the errors reported through it instead of failing the
+// test.
+type errorRecorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *errorRecorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *errorRecorder) Logf(format string, args ...interface{}) {}
+
+var workload = []powerloss.Op{
+	powerloss.Create("/a.txt"),
+	powerloss.Write("/a.txt", strings.Repeat("a", 3000)),
+	powerloss.Write("/a.txt", strings.Repeat("b", 3000)),
+	powerloss.Rename("/a.txt", "/b.txt"),
+	powerloss.Truncate("/b.txt", 10),
+	powerloss.Remove("/b.txt"),
+}
+
+func TestRun(t *testing.T) {
+	newDevice := func() tinyfs.BlockDevice {
+		return tinyfs.NewMemoryDevice(64, 256, 128)
+	}
+	newLFS := func(dev tinyfs.BlockDevice) tinyfs.Filesystem {
+		return littlefs.New(dev).Configure(&littlefs.Config{
+			CacheSize:     64,
+			LookaheadSize: 32,
+			BlockCycles:   500,
+		})
+	}
+
+	t.Run("littlefs", func(t *testing.T) {
+		powerloss.Run(t, newDevice, newLFS, workload)
+	})
+
+	t.Run("fatfs", func(t *testing.T) {
+		// FAT overwrites data in place, so a power cut can leave a file
+		// with a mix of old and new contents
+		r := &errorRecorder{TB: t}
+		powerloss.Run(r, func() tinyfs.BlockDevice {
+			return tinyfs.NewMemoryDevice(64, 256, 4096)
+		}, func(dev tinyfs.BlockDevice) tinyfs.Filesystem {
+			return fatfs.New(dev).Configure(&fatfs.Config{})
+		}, workload)
+		if len(r.errors) != 1 || !strings.HasPrefix(r.errors[0], "power cut after ") {
+			t.Fatalf("expected power cuts to corrupt FAT, was actually %q", r.errors)
+		}
+		t.Log(r.errors[0])
+	})
+
+	t.Run("WorkloadError", func(t *testing.T) {
+		r := &errorRecorder{TB: t}
+		powerloss.Run(r, newDevice, newLFS, []powerloss.Op{powerloss.Write("/missing.txt", "data")})
+		if len(r.errors) != 1 || !strings.HasPrefix(r.errors[0], "write 4 bytes to /missing.txt: ") {
+			t.Errorf("expected the failed write to be reported, was actually %q", r.errors)
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package tinyfs
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -18,15 +19,149 @@ type Filesystem interface {
 	Unmount() error
 }
 
+// ErrBusy is returned when a filesystem is unmounted while files or
+// directories are still open on it.
+var ErrBusy = errors.New("filesystem has open files")
+
+// ErrLocked is returned when a file cannot be locked or opened because of a
+// conflicting lock held through another open file.
+var ErrLocked = errors.New("file is locked")
+
+// ErrTooManyOpenFiles is returned when a file is opened while the limit on
+// the number of open files of the volume has been reached.
+var ErrTooManyOpenFiles = errors.New("too many open files")
+
+// ErrNoSpace is returned when a write cannot complete because the volume is
+// full.
+var ErrNoSpace = errors.New("no space left on device")
+
+// ErrOutOfRange is returned by block devices for accesses beyond the end of
+// the device.
+var ErrOutOfRange = errors.New("access out of range of block device")
+
+// ErrNotMounted is returned by the operations of a filesystem that is not
+// mounted, such as after Unmount or Close. It matches fs.ErrClosed.
+var ErrNotMounted error = notMountedError{}
+
+type notMountedError struct{}
+
+func (notMountedError) Error() string { return "filesystem is not mounted" }
+
+func (notMountedError) Is(target error) bool { return target == os.ErrClosed }
+
+// OpenHandle describes a file or directory that is still open, to help track
+// down handles that are never closed.
+type OpenHandle struct {
+	// Path is the name the file was opened with.
+	Path string
+
+	// Flags are the flags the file was opened with, such as os.O_RDONLY.
+	Flags int
+
+	// CallSite is the function, file and line that opened the file, or empty
+	// if the call stack is not available.
+	CallSite string
+}
+
+// StatFS is implemented by filesystems that can report their capacity and
+// limits.
+type StatFS interface {
+	// StatFS returns information about the mounted filesystem.
+	StatFS() (FSStat, error)
+}
+
+// FSStat describes the capacity and limits of a mounted filesystem, similar to
+// the statvfs structure of POSIX.
+type FSStat struct {
+	// BlockSize is the size in bytes of the unit in which space is allocated,
+	// such as a littlefs block or a FAT cluster.
+	BlockSize int64
+
+	// TotalBytes is the size of the filesystem in bytes, which is a multiple
+	// of BlockSize and excludes space the filesystem reserves for itself.
+	TotalBytes int64
+
+	// FreeBytes is the number of bytes available for new data.
+	FreeBytes int64
+
+	// UsedBytes is the number of bytes in use, including metadata.
+	UsedBytes int64
+
+	// MaxDirEntries is the maximum number of entries in a directory, or 0 if
+	// it is only bounded by the free space. Where the format has directories
+	// of different capacities, it is the smallest one. A file may take up
+	// several entries, such as for a long name on FAT.
+	MaxDirEntries int64
+
+	// NameMax is the maximum length of a file name.
+	NameMax int64
+}
+
 // File specifies the common behavior of the file abstraction in TinyFS; this
 // interface may be changed or superseded by the TinyGo os.FileHandle interface
 // if/when that is merged and standardized.
+//
+// ReadAt and WriteAt follow the io.ReaderAt and io.WriterAt contracts and do
+// not change the current offset of the file used by Read, Write and Seek.
 type File interface {
 	FileHandle
+	io.Seeker
+	io.ReaderAt
+	io.WriterAt
+
+	// Name returns the name of the file as presented to OpenFile.
+	Name() string
+
+	// Stat returns the FileInfo structure describing the file.
+	Stat() (os.FileInfo, error)
+
+	// Sync commits any pending writes of the file to storage.
+	Sync() error
+
+	// Truncate changes the size of the file. It does not change the current
+	// offset of the file, unless that offset is past the new end of the file.
+	Truncate(size int64) error
+
 	IsDir() bool
+
+	// Readdir follows the contract of os.File.Readdir: with n > 0 it returns
+	// at most n entries, and io.EOF once the directory is exhausted; with
+	// n <= 0 it returns all remaining entries.
 	Readdir(n int) (infos []os.FileInfo, err error)
 }
 
+// LockType is the kind of advisory lock placed on a file with Locker.
+type LockType int
+
+const (
+	// LockShared may be held through any number of open files at once.
+	LockShared LockType = iota
+
+	// LockExclusive may only be held through a single open file, and
+	// excludes shared locks.
+	LockExclusive
+)
+
+// Locker is implemented by files that support advisory locking, similar to
+// flock(2). Locks belong to an open file, not to a goroutine, so two files
+// opened on the same path conflict with each other even within a goroutine.
+// They are advisory: they do not prevent reads and writes, only conflicting
+// locks.
+type Locker interface {
+	// Lock places a lock of the given type on the file, waiting until no
+	// other open file holds a conflicting one. A lock already held through
+	// this file is converted to the new type.
+	Lock(typ LockType) error
+
+	// TryLock is like Lock, but fails with an error matching ErrLocked
+	// instead of waiting.
+	TryLock(typ LockType) error
+
+	// Unlock releases the lock held through this file, if any. Closing the
+	// file releases it as well.
+	Unlock() error
+}
+
 // FileHandle is a copy of the experimental os.FileHandle interface in TinyGo
 type FileHandle interface {
 	// Read reads up to len(b) bytes from the file.
@@ -73,6 +208,32 @@ type Syncer interface {
 	Sync() error
 }
 
+// StrictMode selects the rules of flash memory that a MemBlockDevice
+// enforces, to catch drivers that would corrupt data on real flash.
+type StrictMode int
+
+const (
+	// StrictOff lets writes simply overwrite memory, like RAM.
+	StrictOff StrictMode = iota
+
+	// StrictNOR only lets writes clear bits, like programming NOR flash, so
+	// that memory has to be erased before bits can be set again. Writes must
+	// start at and span whole pages of WriteBlockSize bytes.
+	StrictNOR
+
+	// StrictNAND adds the rule of NAND flash that each page may only be
+	// programmed once between erases to those of StrictNOR.
+	StrictNAND
+)
+
+// ErrNotErased is returned by a strict MemBlockDevice for writes to memory
+// that would have to be erased first.
+var ErrNotErased = errors.New("flash memory must be erased before it is programmed")
+
+// ErrUnaligned is returned by a strict MemBlockDevice for writes that do not
+// span whole pages.
+var ErrUnaligned = errors.New("write is not aligned to the write block size")
+
 // MemBlockDevice is a block device implementation backed by a byte slice
 type MemBlockDevice struct {
 	memory     []byte
@@ -80,6 +241,11 @@ type MemBlockDevice struct {
 	blockCount uint32
 	blockSize  uint32
 	pageSize   uint32
+
+	// strict is the flash rules that are enforced, and programmed marks
+	// the pages that were programmed since they were erased for StrictNAND.
+	strict     StrictMode
+	programmed []bool
 }
 
 var _ BlockDevice = (*MemBlockDevice)(nil)
@@ -91,6 +257,7 @@ func NewMemoryDevice(pageSize, blockSize, blockCount int) *MemBlockDevice {
 		pageSize:   uint32(pageSize),
 		blockSize:  uint32(blockSize),
 		blockCount: uint32(blockCount),
+		programmed: make([]bool, blockSize*blockCount/pageSize),
 	}
 	for i := range dev.blankBlock {
 		dev.blankBlock[i] = 0xff
@@ -103,14 +270,66 @@ func NewMemoryDevice(pageSize, blockSize, blockCount int) *MemBlockDevice {
 	return dev
 }
 
+// SetStrict makes the device enforce the rules of flash memory selected by
+// mode. Memory that was written before counts as programmed.
+func (bd *MemBlockDevice) SetStrict(mode StrictMode) *MemBlockDevice {
+	bd.strict = mode
+	return bd
+}
+
+// ReadAt reads from memory. Reads past the end of the device are cut short
+// with ErrOutOfRange.
 func (bd *MemBlockDevice) ReadAt(buf []byte, off int64) (n int, err error) {
-	return copy(buf, bd.memory[off:]), nil
+	if off < 0 || off > int64(len(bd.memory)) {
+		return 0, ErrOutOfRange
+	}
+	n = copy(buf, bd.memory[off:])
+	if n < len(buf) {
+		return n, ErrOutOfRange
+	}
+	return n, nil
 }
 
+// WriteAt writes to memory, following the rules of flash memory selected by
+// SetStrict. Writes that do not fit on the device fail with ErrOutOfRange
+// without writing anything.
 func (bd *MemBlockDevice) WriteAt(buf []byte, off int64) (n int, err error) {
+	if off < 0 || off+int64(len(buf)) > int64(len(bd.memory)) {
+		return 0, ErrOutOfRange
+	}
+	if bd.strict != StrictOff {
+		if err := bd.checkProgram(buf, off); err != nil {
+			return 0, err
+		}
+	}
+	page := int64(bd.pageSize)
+	for p := off / page; p < (off+int64(len(buf))+page-1)/page; p++ {
+		bd.programmed[p] = true
+	}
 	return copy(bd.memory[off:], buf), nil
 }
 
+// checkProgram checks whether buf can be programmed at off on flash memory.
+func (bd *MemBlockDevice) checkProgram(buf []byte, off int64) error {
+	page := int64(bd.pageSize)
+	if off%page != 0 || int64(len(buf))%page != 0 {
+		return fmt.Errorf("%w: %d bytes at %d", ErrUnaligned, len(buf), off)
+	}
+	for i, b := range buf {
+		if b&^bd.memory[off+int64(i)] != 0 {
+			return fmt.Errorf("%w: bits would be set at %d", ErrNotErased, off+int64(i))
+		}
+	}
+	if bd.strict == StrictNAND {
+		for p := off / page; p < (off+int64(len(buf)))/page; p++ {
+			if bd.programmed[p] {
+				return fmt.Errorf("%w: page at %d was programmed already", ErrNotErased, p*page)
+			}
+		}
+	}
+	return nil
+}
+
 func (bd *MemBlockDevice) Size() int64 {
 	return int64(bd.blockSize * bd.blockCount)
 }
@@ -124,6 +343,9 @@ func (bd *MemBlockDevice) EraseBlockSize() int64 {
 }
 
 func (bd *MemBlockDevice) EraseBlocks(start int64, len int64) error {
+	if start < 0 || len < 0 || start+len > int64(bd.blockCount) {
+		return ErrOutOfRange
+	}
 	for i := int64(0); i < len; i++ {
 		if err := bd.eraseBlock(uint32(start + i)); err != nil {
 			return err
@@ -134,6 +356,10 @@ func (bd *MemBlockDevice) EraseBlocks(start int64, len int64) error {
 
 func (bd *MemBlockDevice) eraseBlock(block uint32) error {
 	copy(bd.memory[bd.blockSize*block:], bd.blankBlock)
+	pages := bd.blockSize / bd.pageSize
+	for p := block * pages; p < (block+1)*pages; p++ {
+		bd.programmed[p] = false
+	}
 	return nil
 }
 
@@ -0,0 +1,1050 @@
+// Package tinyfstest implements support for testing implementations of the
+// tinyfs.Filesystem interface, like testing/fstest does for io/fs.
+//
+// TestFilesystem checks the behavior that users of the interface can rely on
+// regardless of the driver, so that differences between drivers show up as
+// test failures:
+//
+//	func TestConformance(t *testing.T) {
+//		tinyfstest.TestFilesystem(t, func() tinyfs.Filesystem {
+//			filesystem := littlefs.New(tinyfs.NewMemoryDevice(64, 256, 2048)).Configure(config)
+//			if err := filesystem.Format(); err != nil {
+//				t.Fatal(err)
+//			}
+//			return filesystem
+//		})
+//	}
+package tinyfstest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"tinygo.org/x/tinyfs"
+	"tinygo.org/x/tinyfs/internal/testutil"
+)
+
+// TestFilesystem checks that filesystems created by newFS follow the contract
+// of tinyfs.Filesystem and tinyfs.File. newFS must return a new, formatted
+// filesystem that is not mounted yet for each of the subtests, which mount
+// it and unmount it again when they finish. New files and directories must be
+// stamped with the current time, to the two seconds that FAT can record.
+//
+// The optional methods implemented by the drivers of this module are checked
+// as well if the filesystem has them: Chtimes, OpenHandles, StatFS and Close
+// on the filesystem, and ReadDir and RewindDir on directories.
+func TestFilesystem(t *testing.T, newFS func() tinyfs.Filesystem) {
+	for _, test := range []struct {
+		name string
+		fn   func(t *testing.T, filesystem tinyfs.Filesystem)
+	}{
+		{"Mkdir", testMkdir},
+		{"Rename", testRename},
+		{"Remove", testRemove},
+		{"Readdir", testReaddir},
+		{"ReaddirPages", testReaddirPages},
+		{"Stat", testStat},
+		{"OpenFlags", testOpenFlags},
+		{"NotDir", testNotDir},
+		{"EOF", testEOF},
+		{"ZeroLength", testZeroLength},
+		{"Seek", testSeek},
+		{"Truncate", testTruncate},
+		{"ReadWriteAt", testReadWriteAt},
+		{"ModTime", testModTime},
+		{"Closed", testClosed},
+		{"OpenHandles", testOpenHandles},
+		{"NoSpace", testNoSpace},
+		{"Remount", testRemount},
+		{"NotMounted", testNotMounted},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			filesystem := newFS()
+			if err := filesystem.Mount(); err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := filesystem.Unmount(); err != nil {
+					t.Errorf("unmount: %v", err)
+				}
+			}()
+			test.fn(t, filesystem)
+		})
+	}
+}
+
+func testMkdir(t *testing.T, filesystem tinyfs.Filesystem) {
+	check(t, filesystem.Mkdir("/dir", 0777))
+	expectDir(t, filesystem, "/dir")
+	check(t, filesystem.Mkdir("/dir/sub", 0777))
+	expectDir(t, filesystem, "/dir/sub")
+
+	testutil.ExpectPathError(t, filesystem.Mkdir("/dir", 0777), "mkdir", "/dir", fs.ErrExist)
+	testutil.ExpectPathError(t, filesystem.Mkdir("/missing/sub", 0777), "mkdir", "/missing/sub", fs.ErrNotExist)
+	writeFile(t, filesystem, "/file.txt", "data")
+	testutil.ExpectPathError(t, filesystem.Mkdir("/file.txt", 0777), "mkdir", "/file.txt", fs.ErrExist)
+	expectFile(t, filesystem, "/file.txt", "data")
+}
+
+func testRename(t *testing.T, filesystem tinyfs.Filesystem) {
+	writeFile(t, filesystem, "/a.txt", "avocado")
+	check(t, filesystem.Rename("/a.txt", "/b.txt"))
+	expectNotExist(t, filesystem, "/a.txt")
+	expectFile(t, filesystem, "/b.txt", "avocado")
+
+	// an existing file is replaced
+	writeFile(t, filesystem, "/c.txt", "burrito")
+	check(t, filesystem.Rename("/b.txt", "/c.txt"))
+	expectNotExist(t, filesystem, "/b.txt")
+	expectFile(t, filesystem, "/c.txt", "avocado")
+
+	// into and out of directories, which move with their contents
+	check(t, filesystem.Mkdir("/dir", 0777))
+	check(t, filesystem.Rename("/c.txt", "/dir/c.txt"))
+	expectFile(t, filesystem, "/dir/c.txt", "avocado")
+	check(t, filesystem.Rename("/dir", "/moved"))
+	expectNotExist(t, filesystem, "/dir")
+	expectFile(t, filesystem, "/moved/c.txt", "avocado")
+
+	err := filesystem.Rename("/missing.txt", "/d.txt")
+	var linkErr *os.LinkError
+	if !errors.As(err, &linkErr) {
+		t.Fatalf("expected *os.LinkError, was actually %T: %v", err, err)
+	}
+	if linkErr.Op != "rename" || linkErr.Old != "/missing.txt" || linkErr.New != "/d.txt" {
+		t.Errorf("unexpected link error: %v", linkErr)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected %v, was actually %v", fs.ErrNotExist, err)
+	}
+	expectNotExist(t, filesystem, "/d.txt")
+}
+
+func testRemove(t *testing.T, filesystem tinyfs.Filesystem) {
+	writeFile(t, filesystem, "/file.txt", "data")
+	check(t, filesystem.Remove("/file.txt"))
+	expectNotExist(t, filesystem, "/file.txt")
+	testutil.ExpectPathError(t, filesystem.Remove("/file.txt"), "remove", "/file.txt", fs.ErrNotExist)
+	testutil.ExpectPathError(t, filesystem.Remove("/missing/file.txt"), "remove", "/missing/file.txt", fs.ErrNotExist)
+
+	// directories can only be removed once they are empty, like with
+	// os.Remove, which reports that as fs.ErrExist
+	check(t, filesystem.Mkdir("/dir", 0777))
+	writeFile(t, filesystem, "/dir/file.txt", "data")
+	testutil.ExpectPathError(t, filesystem.Remove("/dir"), "remove", "/dir", fs.ErrExist)
+	expectFile(t, filesystem, "/dir/file.txt", "data")
+	check(t, filesystem.Remove("/dir/file.txt"))
+	check(t, filesystem.Remove("/dir"))
+	expectNotExist(t, filesystem, "/dir")
+}
+
+func testReaddir(t *testing.T, filesystem tinyfs.Filesystem) {
+	check(t, filesystem.Mkdir("/dir", 0777))
+	check(t, filesystem.Mkdir("/dir/sub", 0777))
+	files := map[string]string{"a.txt": "avocado", "b.txt": "", "c.txt": "chimichanga"}
+	for name, data := range files {
+		writeFile(t, filesystem, "/dir/"+name, data)
+	}
+
+	dir, err := filesystem.Open("/dir")
+	check(t, err)
+	defer dir.Close()
+	if !dir.IsDir() {
+		t.Error("expected the directory to be opened as one")
+	}
+	infos, err := dir.Readdir(0)
+	check(t, err)
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+		if info.Name() == "sub" {
+			if !info.IsDir() {
+				t.Errorf("expected %s to be a directory", info.Name())
+			}
+			continue
+		}
+		if info.IsDir() || info.Size() != int64(len(files[info.Name()])) {
+			t.Errorf("expected %s to be a file of %d bytes, was actually %v of %d bytes",
+				info.Name(), len(files[info.Name()]), info.Mode(), info.Size())
+		}
+	}
+	sort.Strings(names)
+	if strings.Join(names, " ") != "a.txt b.txt c.txt sub" {
+		t.Errorf("expected entries [a.txt b.txt c.txt sub], was actually %v", names)
+	}
+
+	// all entries were returned, so only io.EOF remains
+	infos, err = dir.Readdir(1)
+	if len(infos) != 0 || err != io.EOF {
+		t.Errorf("expected io.EOF, was actually %d entries and %v", len(infos), err)
+	}
+	infos, err = dir.Readdir(0)
+	if len(infos) != 0 || err != nil {
+		t.Errorf("expected no entries and no error, was actually %d entries and %v", len(infos), err)
+	}
+
+	// an empty directory
+	empty, err := filesystem.Open("/dir/sub")
+	check(t, err)
+	defer empty.Close()
+	infos, err = empty.Readdir(10)
+	if len(infos) != 0 || err != io.EOF {
+		t.Errorf("expected io.EOF for an empty directory, was actually %d entries and %v", len(infos), err)
+	}
+
+	// paging through the root
+	root, err := filesystem.Open("/")
+	check(t, err)
+	defer root.Close()
+	infos, err = root.Readdir(1)
+	check(t, err)
+	if len(infos) != 1 || infos[0].Name() != "dir" {
+		t.Errorf("expected the entry dir, was actually %v", infos)
+	}
+	if _, err := root.Readdir(1); err != io.EOF {
+		t.Errorf("expected io.EOF, was actually %v", err)
+	}
+
+	// files are not directories
+	f, err := filesystem.Open("/dir/a.txt")
+	check(t, err)
+	defer f.Close()
+	if f.IsDir() {
+		t.Error("expected a file not to be a directory")
+	}
+	if _, err := f.Readdir(0); err == nil {
+		t.Error("expected Readdir on a file to fail")
+	}
+}
+
+func testStat(t *testing.T, filesystem tinyfs.Filesystem) {
+	writeFile(t, filesystem, "/file.txt", "0123456789")
+	info, err := filesystem.Stat("/file.txt")
+	check(t, err)
+	if info.Name() != "file.txt" || info.Size() != 10 || info.IsDir() || !info.Mode().IsRegular() {
+		t.Errorf("expected file.txt to be a regular file of 10 bytes, was actually %s: %v of %d bytes",
+			info.Name(), info.Mode(), info.Size())
+	}
+
+	check(t, filesystem.Mkdir("/dir", 0777))
+	info, err = filesystem.Stat("/dir")
+	check(t, err)
+	if info.Name() != "dir" || !info.IsDir() || !info.Mode().IsDir() {
+		t.Errorf("expected dir to be a directory, was actually %s: %v", info.Name(), info.Mode())
+	}
+
+	_, err = filesystem.Stat("/missing.txt")
+	testutil.ExpectPathError(t, err, "stat", "/missing.txt", fs.ErrNotExist)
+	_, err = filesystem.Stat("/missing/file.txt")
+	testutil.ExpectPathError(t, err, "stat", "/missing/file.txt", fs.ErrNotExist)
+
+	// the size of open files
+	f, err := filesystem.OpenFile("/file.txt", os.O_WRONLY|os.O_APPEND)
+	check(t, err)
+	defer f.Close()
+	_, err = f.Write([]byte("abc"))
+	check(t, err)
+	info, err = f.Stat()
+	check(t, err)
+	if info.Size() != 13 {
+		t.Errorf("expected the open file to be 13 bytes, was actually %d", info.Size())
+	}
+	check(t, f.Close())
+	info, err = filesystem.Stat("/file.txt")
+	check(t, err)
+	if info.Size() != 13 {
+		t.Errorf("expected the file to be 13 bytes, was actually %d", info.Size())
+	}
+}
+
+func testOpenFlags(t *testing.T, filesystem tinyfs.Filesystem) {
+	_, err := filesystem.Open("/file.txt")
+	testutil.ExpectPathError(t, err, "open", "/file.txt", fs.ErrNotExist)
+	_, err = filesystem.OpenFile("/file.txt", os.O_WRONLY)
+	testutil.ExpectPathError(t, err, "open", "/file.txt", fs.ErrNotExist)
+	_, err = filesystem.OpenFile("/missing/file.txt", os.O_WRONLY|os.O_CREATE)
+	testutil.ExpectPathError(t, err, "open", "/missing/file.txt", fs.ErrNotExist)
+
+	// O_CREATE and O_EXCL
+	f, err := filesystem.OpenFile("/file.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	check(t, err)
+	_, err = f.Write([]byte("hello world"))
+	check(t, err)
+	check(t, f.Close())
+	expectFile(t, filesystem, "/file.txt", "hello world")
+	_, err = filesystem.OpenFile("/file.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	testutil.ExpectPathError(t, err, "open", "/file.txt", fs.ErrExist)
+
+	// without O_TRUNC, writes overwrite the file from the start
+	f, err = filesystem.OpenFile("/file.txt", os.O_WRONLY|os.O_CREATE)
+	check(t, err)
+	_, err = f.Write([]byte("HELLO"))
+	check(t, err)
+	check(t, f.Close())
+	expectFile(t, filesystem, "/file.txt", "HELLO world")
+
+	// O_APPEND writes at the end, wherever the offset is
+	f, err = filesystem.OpenFile("/file.txt", os.O_RDWR|os.O_APPEND)
+	check(t, err)
+	_, err = f.Seek(0, io.SeekStart)
+	check(t, err)
+	_, err = f.Write([]byte("!"))
+	check(t, err)
+	check(t, f.Close())
+	expectFile(t, filesystem, "/file.txt", "HELLO world!")
+
+	// O_TRUNC
+	f, err = filesystem.OpenFile("/file.txt", os.O_WRONLY|os.O_TRUNC)
+	check(t, err)
+	check(t, f.Close())
+	expectFile(t, filesystem, "/file.txt", "")
+
+	// the access mode is enforced
+	writeFile(t, filesystem, "/file.txt", "data")
+	f, err = filesystem.OpenFile("/file.txt", os.O_RDONLY)
+	check(t, err)
+	if _, err := f.Write([]byte("x")); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected writing to a file opened with O_RDONLY to fail with fs.ErrPermission, was actually %v", err)
+	}
+	check(t, f.Close())
+	f, err = filesystem.OpenFile("/file.txt", os.O_WRONLY)
+	check(t, err)
+	if _, err := f.Read(make([]byte, 4)); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected reading from a file opened with O_WRONLY to fail with fs.ErrPermission, was actually %v", err)
+	}
+	check(t, f.Close())
+	f, err = filesystem.OpenFile("/file.txt", os.O_RDWR)
+	check(t, err)
+	_, err = f.Write([]byte("D"))
+	check(t, err)
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(f, buf)
+	check(t, err)
+	if string(buf) != "ata" {
+		t.Errorf("expected to read %q after the write, was actually %q", "ata", buf)
+	}
+	check(t, f.Close())
+	expectFile(t, filesystem, "/file.txt", "Data")
+
+	// directories cannot be opened for writing
+	check(t, filesystem.Mkdir("/dir", 0777))
+	for _, flag := range []int{os.O_WRONLY, os.O_RDWR} {
+		f, err := filesystem.OpenFile("/dir", flag)
+		if err == nil {
+			f.Close()
+		}
+		expectDirMisuse(t, err, "open", "/dir", fs.ErrPermission)
+	}
+}
+
+func testNotDir(t *testing.T, filesystem tinyfs.Filesystem) {
+	// a file used as a directory in a path is reported like a missing one, or
+	// like ENOTDIR on os
+	writeFile(t, filesystem, "/file.txt", "data")
+	for _, tc := range []struct {
+		op  string
+		err error
+	}{
+		{"stat", testutil.Second(filesystem.Stat("/file.txt/x"))},
+		{"open", testutil.Second(filesystem.Open("/file.txt/x"))},
+		{"open", testutil.Second(filesystem.OpenFile("/file.txt/x", os.O_WRONLY|os.O_CREATE))},
+		{"mkdir", filesystem.Mkdir("/file.txt/x", 0777)},
+		{"remove", filesystem.Remove("/file.txt/x")},
+	} {
+		expectDirMisuse(t, tc.err, tc.op, "/file.txt/x", fs.ErrNotExist)
+	}
+	expectFile(t, filesystem, "/file.txt", "data")
+}
+
+func testEOF(t *testing.T, filesystem tinyfs.Filesystem) {
+	writeFile(t, filesystem, "/file.txt", "0123456789")
+	f, err := filesystem.Open("/file.txt")
+	check(t, err)
+	defer f.Close()
+
+	buf := make([]byte, 8)
+	n, err := f.Read(buf)
+	if n != 8 || err != nil {
+		t.Errorf("expected to read 8 bytes, was actually %d and %v", n, err)
+	}
+	n, err = f.Read(buf)
+	if n != 2 || (err != nil && err != io.EOF) {
+		t.Errorf("expected to read the last 2 bytes, was actually %d and %v", n, err)
+	}
+	if err == nil {
+		n, err = f.Read(buf)
+	}
+	if n != 0 || err != io.EOF {
+		t.Errorf("expected io.EOF at the end of the file, was actually %d and %v", n, err)
+	}
+	if n, err := f.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("expected io.EOF again, was actually %d and %v", n, err)
+	}
+
+	// ReadAt reports io.EOF for short reads
+	n, err = f.ReadAt(buf, 5)
+	if n != 5 || err != io.EOF || string(buf[:n]) != "56789" {
+		t.Errorf("expected to read %q and io.EOF, was actually %q and %v", "56789", buf[:n], err)
+	}
+	n, err = f.ReadAt(buf[:5], 5)
+	if n != 5 || err != nil {
+		t.Errorf("expected to read 5 bytes up to the end, was actually %d and %v", n, err)
+	}
+	if n, err := f.ReadAt(buf, 10); n != 0 || err != io.EOF {
+		t.Errorf("expected io.EOF at the end, was actually %d and %v", n, err)
+	}
+	if n, err := f.ReadAt(buf, 100); n != 0 || err != io.EOF {
+		t.Errorf("expected io.EOF past the end, was actually %d and %v", n, err)
+	}
+	if _, err := f.ReadAt(buf, -1); err == nil {
+		t.Error("expected ReadAt with a negative offset to fail")
+	}
+
+	// the data can be read in one go
+	_, err = f.Seek(0, io.SeekStart)
+	check(t, err)
+	data, err := io.ReadAll(f)
+	check(t, err)
+	if string(data) != "0123456789" {
+		t.Errorf("expected %q, was actually %q", "0123456789", data)
+	}
+
+	// empty files are at their end immediately
+	writeFile(t, filesystem, "/empty.txt", "")
+	empty, err := filesystem.Open("/empty.txt")
+	check(t, err)
+	defer empty.Close()
+	if n, err := empty.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("expected io.EOF for an empty file, was actually %d and %v", n, err)
+	}
+}
+
+func testZeroLength(t *testing.T, filesystem tinyfs.Filesystem) {
+	writeFile(t, filesystem, "/file.txt", "data")
+	f, err := filesystem.OpenFile("/file.txt", os.O_RDWR)
+	check(t, err)
+	defer f.Close()
+
+	if n, err := f.Read(nil); n != 0 || err != nil {
+		t.Errorf("expected reading nothing to succeed, was actually %d and %v", n, err)
+	}
+	if n, err := f.Write(nil); n != 0 || err != nil {
+		t.Errorf("expected writing nothing to succeed, was actually %d and %v", n, err)
+	}
+	if n, err := f.ReadAt([]byte{}, 2); n != 0 || err != nil {
+		t.Errorf("expected reading nothing at an offset to succeed, was actually %d and %v", n, err)
+	}
+	if n, err := f.WriteAt([]byte{}, 2); n != 0 || err != nil {
+		t.Errorf("expected writing nothing at an offset to succeed, was actually %d and %v", n, err)
+	}
+	if pos, err := f.Seek(0, io.SeekCurrent); pos != 0 || err != nil {
+		t.Errorf("expected the offset to stay at 0, was actually %d and %v", pos, err)
+	}
+	check(t, f.Close())
+	expectFile(t, filesystem, "/file.txt", "data")
+
+	// writing nothing to a new file still creates it
+	writeFile(t, filesystem, "/empty.txt", "")
+	expectFile(t, filesystem, "/empty.txt", "")
+}
+
+func testSeek(t *testing.T, filesystem tinyfs.Filesystem) {
+	writeFile(t, filesystem, "/file.txt", "0123456789")
+	f, err := filesystem.OpenFile("/file.txt", os.O_RDWR)
+	check(t, err)
+	defer f.Close()
+
+	for _, tc := range []struct {
+		offset   int64
+		whence   int
+		expected int64
+	}{
+		{3, io.SeekStart, 3},
+		{2, io.SeekCurrent, 5},
+		{-1, io.SeekCurrent, 4},
+		{-2, io.SeekEnd, 8},
+		{0, io.SeekEnd, 10},
+		{0, io.SeekStart, 0},
+	} {
+		pos, err := f.Seek(tc.offset, tc.whence)
+		if pos != tc.expected || err != nil {
+			t.Errorf("expected Seek(%d, %d) to return %d, was actually %d and %v",
+				tc.offset, tc.whence, tc.expected, pos, err)
+		}
+	}
+	if _, err := f.Seek(-1, io.SeekStart); err == nil {
+		t.Error("expected seeking before the start to fail")
+	}
+
+	// seeking past the end and writing fills the gap with zeros
+	_, err = f.Seek(12, io.SeekStart)
+	check(t, err)
+	_, err = f.Write([]byte("x"))
+	check(t, err)
+	check(t, f.Close())
+	expectFile(t, filesystem, "/file.txt", "0123456789\x00\x00x")
+}
+
+func testTruncate(t *testing.T, filesystem tinyfs.Filesystem) {
+	writeFile(t, filesystem, "/file.txt", "0123456789")
+	f, err := filesystem.OpenFile("/file.txt", os.O_RDWR)
+	check(t, err)
+	defer f.Close()
+	check(t, f.Truncate(4))
+	info, err := f.Stat()
+	check(t, err)
+	if info.Size() != 4 {
+		t.Errorf("expected the size to be 4 after truncating, was actually %d", info.Size())
+	}
+	check(t, f.Truncate(6))
+	if err := f.Truncate(-1); err == nil {
+		t.Error("expected truncating to a negative size to fail")
+	}
+	check(t, f.Close())
+	expectFile(t, filesystem, "/file.txt", "0123\x00\x00")
+}
+
+func testClosed(t *testing.T, filesystem tinyfs.Filesystem) {
+	writeFile(t, filesystem, "/file.txt", "data")
+	f, err := filesystem.OpenFile("/file.txt", os.O_RDWR)
+	check(t, err)
+	check(t, f.Close())
+	buf := make([]byte, 4)
+	for name, err := range map[string]error{
+		"Read":  testutil.Second(f.Read(buf)),
+		"Write": testutil.Second(f.Write(buf)),
+		"Seek":  testutil.Second(f.Seek(0, io.SeekStart)),
+		"Stat":  testutil.Second(f.Stat()),
+	} {
+		if !errors.Is(err, fs.ErrClosed) {
+			t.Errorf("expected %s on a closed file to fail with %v, was actually %v", name, fs.ErrClosed, err)
+		}
+	}
+}
+
+// chtimer is implemented by filesystems that can change modification times.
+type chtimer interface {
+	Chtimes(path string, atime time.Time, mtime time.Time) error
+}
+
+// handleLister is implemented by filesystems that track their open files.
+type handleLister interface {
+	OpenHandles() []tinyfs.OpenHandle
+}
+
+// dirReader is implemented by directories that support fs.ReadDirFile and
+// can be read again from the start.
+type dirReader interface {
+	ReadDir(n int) ([]fs.DirEntry, error)
+	RewindDir() error
+}
+
+func testReaddirPages(t *testing.T, filesystem tinyfs.Filesystem) {
+	check(t, filesystem.Mkdir("/logs", 0777))
+	var expected []string
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("log%d.txt", i)
+		writeFile(t, filesystem, "/logs/"+name, strings.Repeat("x", i))
+		expected = append(expected, name)
+	}
+	check(t, filesystem.Mkdir("/logs/old", 0777))
+	expected = append(expected, "old")
+
+	dir, err := filesystem.Open("/logs")
+	check(t, err)
+	defer dir.Close()
+	var names []string
+	for {
+		infos, err := dir.Readdir(3)
+		if err == io.EOF {
+			if len(infos) != 0 {
+				t.Fatalf("expected no entries with io.EOF, was actually %d", len(infos))
+			}
+			break
+		}
+		check(t, err)
+		if len(infos) == 0 || len(infos) > 3 {
+			t.Fatalf("expected 1 to 3 entries, was actually %d", len(infos))
+		}
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+	}
+	testutil.ExpectNames(t, expected, names)
+
+	// reading all remaining entries at the end is not an error
+	infos, err := dir.Readdir(0)
+	if len(infos) != 0 || err != nil {
+		t.Fatalf("expected no remaining entries, was actually %d and %v", len(infos), err)
+	}
+
+	reader, ok := dir.(dirReader)
+	if !ok {
+		return
+	}
+	check(t, reader.RewindDir())
+	first, err := reader.ReadDir(4)
+	check(t, err)
+	rest, err := reader.ReadDir(-1)
+	check(t, err)
+	names = nil
+	for _, entry := range append(first, rest...) {
+		names = append(names, entry.Name())
+		info, err := entry.Info()
+		check(t, err)
+		if entry.IsDir() != info.IsDir() || entry.Type() != info.Mode().Type() {
+			t.Errorf("%s: expected type %v, was actually %v", entry.Name(), info.Mode().Type(), entry.Type())
+		}
+	}
+	testutil.ExpectNames(t, expected, names)
+	if _, err := reader.ReadDir(1); err != io.EOF {
+		t.Errorf("expected io.EOF, was actually %v", err)
+	}
+
+	f, err := filesystem.Open("/logs/log1.txt")
+	check(t, err)
+	defer f.Close()
+	if reader, ok := f.(dirReader); ok {
+		if err := reader.RewindDir(); err == nil {
+			t.Error("expected RewindDir on a file to fail")
+		}
+	}
+}
+
+func testReadWriteAt(t *testing.T, filesystem tinyfs.Filesystem) {
+	f, err := filesystem.OpenFile("/at.txt", os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	check(t, err)
+	defer f.Close()
+	_, err = f.Write([]byte("0123456789"))
+	check(t, err)
+	_, err = f.Seek(3, io.SeekStart)
+	check(t, err)
+
+	buf := make([]byte, 4)
+	if n, err := f.ReadAt(buf, 5); n != 4 || err != nil || string(buf) != "5678" {
+		t.Errorf("expected to read %q, was actually %q and %v", "5678", buf[:n], err)
+	}
+	if n, err := f.ReadAt(buf, 8); n != 2 || err != io.EOF || string(buf[:n]) != "89" {
+		t.Errorf("expected to read %q and io.EOF, was actually %q and %v", "89", buf[:n], err)
+	}
+
+	// writing past the end fills the gap with zeros
+	_, err = f.WriteAt([]byte("ab"), 1)
+	check(t, err)
+	_, err = f.WriteAt([]byte("yz"), 12)
+	check(t, err)
+	buf = make([]byte, 14)
+	_, err = f.ReadAt(buf, 0)
+	check(t, err)
+	if string(buf) != "0ab3456789\x00\x00yz" {
+		t.Errorf("expected %q, was actually %q", "0ab3456789\x00\x00yz", buf)
+	}
+
+	// the offset used by Read and Write is unchanged
+	buf = make([]byte, 2)
+	_, err = io.ReadFull(f, buf)
+	check(t, err)
+	if string(buf) != "34" {
+		t.Errorf("expected to read %q at the offset, was actually %q", "34", buf)
+	}
+
+	info, err := f.Stat()
+	check(t, err)
+	if info.Name() != "at.txt" || info.Size() != 14 || info.IsDir() {
+		t.Errorf("expected at.txt to be a file of 14 bytes, was actually %s: %v of %d bytes",
+			info.Name(), info.Mode(), info.Size())
+	}
+	check(t, f.Truncate(3))
+	check(t, f.Sync())
+	info, err = filesystem.Stat("/at.txt")
+	check(t, err)
+	if info.Size() != 3 {
+		t.Errorf("expected the size to be 3 after truncating, was actually %d", info.Size())
+	}
+}
+
+func testModTime(t *testing.T, filesystem tinyfs.Filesystem) {
+	// FAT only records times to two seconds
+	before := time.Now().Add(-2 * time.Second)
+	writeFile(t, filesystem, "/timed.txt", "tick")
+	check(t, filesystem.Mkdir("/timed", 0777))
+	after := time.Now().Add(2 * time.Second)
+	for _, name := range []string{"/timed.txt", "/timed"} {
+		info, err := filesystem.Stat(name)
+		check(t, err)
+		if mtime := info.ModTime(); mtime.Before(before) || mtime.After(after) {
+			t.Errorf("%s: expected a modification time between %v and %v, was actually %v", name, before, after, mtime)
+		}
+	}
+
+	ch, ok := filesystem.(chtimer)
+	if !ok {
+		return
+	}
+	mtime := time.Date(2021, time.March, 14, 15, 9, 26, 0, time.Local)
+	check(t, ch.Chtimes("/timed.txt", mtime, mtime))
+	info, err := filesystem.Stat("/timed.txt")
+	check(t, err)
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("expected modification time %v, was actually %v", mtime, info.ModTime())
+	}
+	root, err := filesystem.Open("/")
+	check(t, err)
+	defer root.Close()
+	infos, err := root.Readdir(0)
+	check(t, err)
+	for _, info := range infos {
+		if info.Name() == "timed.txt" && !info.ModTime().Equal(mtime) {
+			t.Errorf("expected Readdir modification time %v, was actually %v", mtime, info.ModTime())
+		}
+	}
+	err = ch.Chtimes("/missing", mtime, mtime)
+	testutil.ExpectPathError(t, err, "chtimes", "/missing", fs.ErrNotExist)
+}
+
+func testOpenHandles(t *testing.T, filesystem tinyfs.Filesystem) {
+	lister, ok := filesystem.(handleLister)
+	if !ok {
+		t.Skip("OpenHandles is not implemented")
+	}
+	check(t, filesystem.Mkdir("/dir", 0777))
+	dir, err := filesystem.Open("/dir")
+	check(t, err)
+	f, err := filesystem.OpenFile("/file.txt", os.O_RDWR|os.O_CREATE)
+	check(t, err)
+	defer f.Close()
+
+	handles := lister.OpenHandles()
+	if len(handles) != 2 {
+		t.Fatalf("expected 2 open handles, was actually %+v", handles)
+	}
+	for i, expected := range []tinyfs.OpenHandle{
+		{Path: "/dir", Flags: os.O_RDONLY},
+		{Path: "/file.txt", Flags: os.O_RDWR | os.O_CREATE},
+	} {
+		handle := handles[i]
+		if handle.Path != expected.Path || handle.Flags != expected.Flags {
+			t.Errorf("expected %q opened with %#x, was actually %q opened with %#x", expected.Path, expected.Flags, handle.Path, handle.Flags)
+		}
+		if !strings.Contains(handle.CallSite, "tinyfstest.testOpenHandles") {
+			t.Errorf("expected the call site in testOpenHandles, was actually %q", handle.CallSite)
+		}
+	}
+
+	// the filesystem cannot be unmounted while files are open
+	testutil.ExpectPathError(t, filesystem.Unmount(), "unmount", "/", tinyfs.ErrBusy)
+	check(t, dir.Close())
+	testutil.ExpectPathError(t, filesystem.Unmount(), "unmount", "/", tinyfs.ErrBusy)
+	if handles := lister.OpenHandles(); len(handles) != 1 || handles[0].Path != "/file.txt" {
+		t.Fatalf("expected only /file.txt to be open, was actually %+v", handles)
+	}
+	_, err = f.Write([]byte("still mounted"))
+	check(t, err)
+	check(t, f.Close())
+	if handles := lister.OpenHandles(); len(handles) != 0 {
+		t.Fatalf("expected no open handles, was actually %+v", handles)
+	}
+}
+
+func testNoSpace(t *testing.T, filesystem tinyfs.Filesystem) {
+	// fill the volume, which may only be noticed when the file is closed
+	f, err := filesystem.OpenFile("/big", os.O_WRONLY|os.O_CREATE)
+	check(t, err)
+	chunk := bytes.Repeat([]byte("0123456789abcdef"), 256)
+	for i := 0; err == nil; i++ {
+		if i == 1<<16 {
+			t.Fatal("expected the volume to fill up")
+		}
+		_, err = f.Write(chunk)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if !errors.Is(err, tinyfs.ErrNoSpace) {
+		t.Fatalf("expected %v, was actually %v", tinyfs.ErrNoSpace, err)
+	}
+
+	// the space is available again once the file is removed
+	check(t, filesystem.Remove("/big"))
+	writeFile(t, filesystem, "/file.txt", "data")
+	expectFile(t, filesystem, "/file.txt", "data")
+}
+
+func testRemount(t *testing.T, filesystem tinyfs.Filesystem) {
+	writeFile(t, filesystem, "/file.txt", "data")
+
+	// mounting a mounted filesystem remounts it, and unmounting an unmounted
+	// one does nothing
+	check(t, filesystem.Mount())
+	expectFile(t, filesystem, "/file.txt", "data")
+	check(t, filesystem.Unmount())
+	check(t, filesystem.Unmount())
+	check(t, filesystem.Mount())
+	expectFile(t, filesystem, "/file.txt", "data")
+}
+
+func testNotMounted(t *testing.T, filesystem tinyfs.Filesystem) {
+	writeFile(t, filesystem, "/file.txt", "data")
+	check(t, filesystem.Unmount())
+	expectNotMounted(t, filesystem)
+	check(t, filesystem.Mount())
+	expectFile(t, filesystem, "/file.txt", "data")
+	expectNotExist(t, filesystem, "/new.txt")
+	expectNotExist(t, filesystem, "/new")
+
+	closer, ok := filesystem.(io.Closer)
+	if !ok {
+		return
+	}
+
+	// closing the filesystem closes its files, which become stale
+	f, err := filesystem.OpenFile("/file.txt", os.O_RDWR)
+	check(t, err)
+	dir, err := filesystem.Open("/")
+	check(t, err)
+	check(t, closer.Close())
+	buf := make([]byte, 4)
+	for _, tc := range []struct {
+		op  string
+		err error
+	}{
+		{"Read", testutil.Second(f.Read(buf))},
+		{"ReadAt", testutil.Second(f.ReadAt(buf, 0))},
+		{"Write", testutil.Second(f.Write(buf))},
+		{"WriteAt", testutil.Second(f.WriteAt(buf, 0))},
+		{"Seek", testutil.Second(f.Seek(0, io.SeekStart))},
+		{"Stat", testutil.Second(f.Stat())},
+		{"Sync", f.Sync()},
+		{"Truncate", f.Truncate(0)},
+		{"Readdir", testutil.Second(dir.Readdir(0))},
+	} {
+		if !errors.Is(tc.err, fs.ErrClosed) {
+			t.Errorf("%s: expected %v, was actually %v", tc.op, fs.ErrClosed, tc.err)
+		}
+	}
+	check(t, f.Close())
+	check(t, dir.Close())
+	if lister, ok := filesystem.(handleLister); ok {
+		if handles := lister.OpenHandles(); len(handles) != 0 {
+			t.Errorf("expected no open handles, was actually %+v", handles)
+		}
+	}
+	expectNotMounted(t, filesystem)
+}
+
+// expectNotMounted checks that the operations of a filesystem that is not
+// mounted fail with an error matching fs.ErrClosed.
+func expectNotMounted(t *testing.T, filesystem tinyfs.Filesystem) {
+	t.Helper()
+	for _, tc := range []struct {
+		op   string
+		path string
+		err  error
+	}{
+		{"stat", "/file.txt", testutil.Second(filesystem.Stat("/file.txt"))},
+		{"open", "/file.txt", testutil.Second(filesystem.Open("/file.txt"))},
+		{"open", "/new.txt", testutil.Second(filesystem.OpenFile("/new.txt", os.O_WRONLY|os.O_CREATE))},
+		{"mkdir", "/new", filesystem.Mkdir("/new", 0777)},
+		{"remove", "/file.txt", filesystem.Remove("/file.txt")},
+	} {
+		testutil.ExpectPathError(t, tc.err, tc.op, tc.path, fs.ErrClosed)
+	}
+	err := filesystem.Rename("/file.txt", "/renamed.txt")
+	if linkErr, ok := err.(*os.LinkError); !ok || !errors.Is(linkErr, fs.ErrClosed) {
+		t.Errorf("expected rename to fail with %v, was actually %v", fs.ErrClosed, err)
+	}
+	if statFS, ok := filesystem.(tinyfs.StatFS); ok {
+		testutil.ExpectPathError(t, testutil.Second(statFS.StatFS()), "statfs", "/", fs.ErrClosed)
+	}
+	if ch, ok := filesystem.(chtimer); ok {
+		now := time.Now()
+		testutil.ExpectPathError(t, ch.Chtimes("/file.txt", now, now), "chtimes", "/file.txt", fs.ErrClosed)
+	}
+}
+
+// TestMaxOpenFiles checks that filesystems created by newFS refuse to open
+// more than max files and directories at once. newFS must return a new,
+// formatted filesystem that is not mounted yet and is configured with that
+// limit.
+func TestMaxOpenFiles(t *testing.T, newFS func() tinyfs.Filesystem, max int) {
+	filesystem := newFS()
+	check(t, filesystem.Mount())
+	defer func() {
+		if err := filesystem.Unmount(); err != nil {
+			t.Errorf("unmount: %v", err)
+		}
+	}()
+
+	var files []tinyfs.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for i := 0; i < max-1; i++ {
+		f, err := filesystem.OpenFile(fmt.Sprintf("/%d.txt", i), os.O_RDWR|os.O_CREATE)
+		check(t, err)
+		files = append(files, f)
+	}
+	root, err := filesystem.Open("/")
+	check(t, err)
+	_, err = filesystem.OpenFile("/extra.txt", os.O_RDWR|os.O_CREATE)
+	testutil.ExpectPathError(t, err, "open", "/extra.txt", tinyfs.ErrTooManyOpenFiles)
+	expectNotExist(t, filesystem, "/extra.txt")
+
+	// failed opens do not count towards the limit
+	check(t, root.Close())
+	_, err = filesystem.Open("/missing.txt")
+	testutil.ExpectPathError(t, err, "open", "/missing.txt", fs.ErrNotExist)
+	f, err := filesystem.OpenFile("/extra.txt", os.O_RDWR|os.O_CREATE)
+	check(t, err)
+	files = append(files, f)
+}
+
+// TestBlockDeviceErrors checks that filesystems report the errors of their
+// block device, and that they can be mounted and written to again once the
+// device works again. newDevice must return a new, empty block device and
+// newFS a new, configured filesystem on the given device, which wraps the one
+// from newDevice in a tinyfs.FaultDevice to inject the errors.
+func TestBlockDeviceErrors(t *testing.T, newDevice func() tinyfs.BlockDevice, newFS func(tinyfs.BlockDevice) tinyfs.Filesystem) {
+	dev := tinyfs.NewFaultDevice(newDevice())
+	filesystem := newFS(dev)
+	check(t, filesystem.Format())
+	check(t, filesystem.Mount())
+	defer func() {
+		dev.PowerOn()
+		if err := filesystem.Unmount(); err != nil {
+			t.Errorf("unmount: %v", err)
+		}
+	}()
+	contents := strings.Repeat("old contents ", 100)
+	writeFile(t, filesystem, "/file.txt", contents)
+
+	// tryWriteFile writes a file like writeFile, but returns the error
+	tryWriteFile := func(name, data string) error {
+		f, err := filesystem.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write([]byte(data))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+	remountAndRewrite := func(t *testing.T) {
+		t.Helper()
+		check(t, filesystem.Mount())
+		writeFile(t, filesystem, "/file.txt", "after the failure")
+		expectFile(t, filesystem, "/file.txt", "after the failure")
+	}
+
+	t.Run("Read", func(t *testing.T) {
+		check(t, filesystem.Unmount())
+		dev.FailRead(1)
+		err := filesystem.Mount()
+		if pathErr, ok := err.(*os.PathError); !ok || pathErr.Op != "mount" || pathErr.Path != "/" {
+			t.Fatalf("expected mount to fail with *os.PathError, was actually %T: %v", err, err)
+		}
+		check(t, filesystem.Mount())
+		expectFile(t, filesystem, "/file.txt", contents)
+	})
+
+	t.Run("Program", func(t *testing.T) {
+		dev.FailProgram(1)
+		if err := tryWriteFile("/file.txt", strings.Repeat("new contents ", 100)); err == nil {
+			t.Fatal("expected the failed program to be reported")
+		}
+		remountAndRewrite(t)
+	})
+
+	t.Run("PowerCut", func(t *testing.T) {
+		dev.CutPower(1, int(dev.WriteBlockSize()/2))
+		if err := tryWriteFile("/file.txt", strings.Repeat("new contents ", 100)); err == nil {
+			t.Fatal("expected the power cut to be reported")
+		}
+		if !dev.PowerLost() {
+			t.Fatal("expected the power to be cut")
+		}
+		dev.PowerOn()
+		remountAndRewrite(t)
+	})
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeFile(t *testing.T, filesystem tinyfs.Filesystem, name string, data string) {
+	t.Helper()
+	f, err := filesystem.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	check(t, err)
+	if _, err := f.Write([]byte(data)); err != nil {
+		f.Close()
+		t.Fatal(err)
+	}
+	check(t, f.Close())
+}
+
+func expectFile(t *testing.T, filesystem tinyfs.Filesystem, name string, expected string) {
+	t.Helper()
+	f, err := filesystem.Open(name)
+	check(t, err)
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	check(t, err)
+	if !bytes.Equal(data, []byte(expected)) {
+		t.Errorf("expected %s to contain %q, was actually %q", name, expected, data)
+	}
+}
+
+func expectDir(t *testing.T, filesystem tinyfs.Filesystem, name string) {
+	t.Helper()
+	info, err := filesystem.Stat(name)
+	check(t, err)
+	if !info.IsDir() {
+		t.Errorf("expected %s to be a directory, was actually %v", name, info.Mode())
+	}
+}
+
+// expectDirMisuse checks that err is an *os.PathError for op on path, which
+// reports a file used as a directory or the other way around. FatFs has no
+// ENOTDIR or EISDIR and reports such errors as target, while littlefs, like
+// os, reports errors that match none of the fs sentinels.
+func expectDirMisuse(t *testing.T, err error, op string, path string, target error) {
+	t.Helper()
+	pathErr, ok := err.(*os.PathError)
+	if !ok {
+		t.Errorf("expected *os.PathError, was actually %T: %v", err, err)
+		return
+	}
+	if pathErr.Op != op || pathErr.Path != path {
+		t.Errorf("expected op %q on %q, was actually %q on %q", op, path, pathErr.Op, pathErr.Path)
+	}
+	if errors.Is(err, target) {
+		return
+	}
+	for _, sentinel := range []error{fs.ErrNotExist, fs.ErrExist, fs.ErrPermission, fs.ErrInvalid, fs.ErrClosed} {
+		if errors.Is(err, sentinel) {
+			t.Errorf("expected %v or an error matching no fs sentinel, was actually %v", target, err)
+		}
+	}
+}
+
+func expectNotExist(t *testing.T, filesystem tinyfs.Filesystem, name string) {
+	t.Helper()
+	if _, err := filesystem.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected %s not to exist, was actually %v", name, err)
+	}
+}